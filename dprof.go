@@ -2,13 +2,180 @@ package dprof
 
 import (
 	"github.com/dan-and-dna/dprof/internal"
+	"github.com/dan-and-dna/dprof/stackerr"
+	"github.com/dan-and-dna/dprof/stat"
 	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"time"
 )
 
-func GetStatRegistry() *prometheus.Registry {
-	return internal.GetSingleInst().GetStatRegistry()
+// Option dprof的配置项，只在第一次调用GetStatRegistry或DumpProfiles时生效
+type Option = internal.Option
+
+// Rule 触发规则，条件满足且过了冷却时间就抓取剖析
+type Rule = internal.Rule
+
+// Anomaly 基线异常检测，学习指标的均值和方差，偏离基线时抓取剖析
+type Anomaly = internal.Anomaly
+
+// 规则可以抓取的剖析类型
+const (
+	ProfileCPU       = internal.DumpCPU
+	ProfileHeap      = internal.DumpMEM
+	ProfileGoroutine = internal.DumpGoroutine
+	ProfileTrace     = internal.DumpTrace
+	ProfileFd        = internal.DumpFd // fd清单和所有协程的调用栈，只支持linux
+)
+
+// DefaultRules 默认的触发规则，可以在此基础上增加自己的规则
+func DefaultRules() []Rule {
+	return internal.DefaultRules()
+}
+
+// WithLegacyMetrics 是否继续输出旧的指标名，用于过渡，默认开启，迁移到新指标后可以关闭
+func WithLegacyMetrics(enable bool) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithLegacyMetrics(enable))
+	}
+}
+
+// WithRegisterer 把指标注册到调用者的registerer，此时GetStatRegistry返回nil
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithRegisterer(registerer))
+	}
+}
+
+// WithNamespace 指标名前缀，默认为dprof
+func WithNamespace(namespace string) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithNamespace(namespace))
+	}
+}
+
+// WithConstLabels 所有指标都带上的固定label
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithConstLabels(labels))
+	}
+}
+
+// WithCollectorInterval 修改采集器的采集间隔，interval为0时不采集，
+// 内置的有process(250ms)、runtime(1s)，linux下还有procstat、cgroup_cpu、psi、procself(1s)和tcp(5s)
+func WithCollectorInterval(name string, interval time.Duration) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithInterval(name, interval))
+	}
+}
+
+// WithWindow 增加或修改一个滚动窗口，规则中通过 m.Window(name) 引用均值、标准差、EWMA、分位数和斜率等，
+// value为nil时只修改内置窗口的长度，内置窗口见stat.WindowCpuUsage等
+func WithWindow(name string, duration time.Duration, value func(m *stat.Metrics) float64) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithWindow(name, duration, value))
+	}
+}
+
+// WithDumpDir dump文件的目录，默认为当前目录
+func WithDumpDir(dir string) Option {
+	return func(opts *internal.Options) {
+		opts.DumpDir = dir
+	}
+}
+
+// WithLogger 日志，默认为slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(opts *internal.Options) {
+		opts.Logger = logger
+	}
+}
+
+// WithLogLevel dprof输出日志的最低级别
+func WithLogLevel(level slog.Leveler) Option {
+	return func(opts *internal.Options) {
+		opts.LogLevel = level
+	}
+}
+
+// WithRuntimeLog 每隔interval输出一次运行时指标日志，默认不输出
+func WithRuntimeLog(interval time.Duration) Option {
+	return func(opts *internal.Options) {
+		opts.StatOptions = append(opts.StatOptions, stat.WithRuntimeLog(interval))
+	}
+}
+
+// WithRepanic 恢复panic并输出崩溃报告后是否再次panic，默认不会
+func WithRepanic(enable bool) Option {
+	return func(opts *internal.Options) {
+		opts.Repanic = enable
+	}
+}
+
+// WithCrashOutput 是否把没有恢复的panic和运行时致命错误的输出重定向到dump目录，默认开启，
+// 下一次启动时会重命名为崩溃报告
+func WithCrashOutput(enable bool) Option {
+	return func(opts *internal.Options) {
+		opts.CrashOutput = enable
+	}
+}
+
+// WithRules 替换默认的触发规则
+//
+//	dprof.WithRules(append(dprof.DefaultRules(), dprof.Rule{
+//		Name:     "io_pressure",
+//		Kinds:    []int{dprof.ProfileGoroutine},
+//		Key:      500,
+//		Interval: 300,
+//		For:      10 * time.Second,
+//		Condition: func(m *stat.Metrics) bool {
+//			return m.Pressure().Io.Full.Avg10 > 10
+//		},
+//		Exit: func(m *stat.Metrics) bool {
+//			return m.Pressure().Io.Full.Avg10 < 5
+//		},
+//	})...)
+func WithRules(rules ...Rule) Option {
+	return func(opts *internal.Options) {
+		opts.Rules = rules
+	}
+}
+
+// WithAnomalies 基线异常检测，适合负载随时间变化很大、不好设置固定阈值的服务，基线保存在dump目录的状态文件中
+//
+//	dprof.WithAnomalies(dprof.Anomaly{
+//		Name:      "cpu_anomaly",
+//		Kinds:     []int{dprof.ProfileCPU},
+//		Interval:  300,
+//		KeepTime:  5,
+//		Value:     func(m *stat.Metrics) float64 { return float64(m.CpuUsage) },
+//		HourOfDay: true,
+//	})
+func WithAnomalies(anomalies ...Anomaly) Option {
+	return func(opts *internal.Options) {
+		opts.Anomalies = anomalies
+	}
+}
+
+// GetStatRegistry 返回dprof自己创建的registry，使用WithRegisterer时返回nil
+func GetStatRegistry(opts ...Option) *prometheus.Registry {
+	return internal.GetSingleInst(opts...).GetStatRegistry()
+}
+
+// GetErrorAggregator 返回按出错位置统计stackerr错误的Aggregator，指标注册在dprof的registry上
+func GetErrorAggregator(opts ...Option) *stackerr.Aggregator {
+	return internal.GetSingleInst(opts...).GetErrorAggregator()
+}
+
+// ObserveError 统计一次错误，返回错误的指纹；dprof还没有初始化时只计算指纹，不统计，也不会用默认配置初始化
+func ObserveError(err error) string {
+	d := internal.LookupSingleInst()
+	if d == nil {
+		return stackerr.Fingerprint(err, 0)
+	}
+
+	return d.GetErrorAggregator().Observe(err)
 }
 
-func DumpProfiles() {
-	internal.GetSingleInst().DumpProfiles()
+func DumpProfiles(opts ...Option) {
+	internal.GetSingleInst(opts...).DumpProfiles()
 }
@@ -0,0 +1,69 @@
+package dprof
+
+import (
+	"github.com/dan-and-dna/dprof/internal"
+	"github.com/dan-and-dna/dprof/stackerr"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// resetSingleInst 测试前后都清除单例，测试的顺序和次数不影响结果
+func resetSingleInst(t *testing.T) {
+	t.Helper()
+
+	internal.ResetSingleInst()
+	t.Cleanup(internal.ResetSingleInst)
+}
+
+// TestRecover_BeforeInit 初始化之前恢复的panic只输出日志，不会创建单例
+func TestRecover_BeforeInit(t *testing.T) {
+	resetSingleInst(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer Recover()
+		panic("before init")
+	}()
+	<-done
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("before init")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status: got %d, want 500", w.Code)
+	}
+
+	if internal.LookupSingleInst() != nil {
+		t.Fatal("Recover and Middleware should not create the instance")
+	}
+}
+
+// TestObserveError_BeforeInit 初始化之前调用不会用默认配置创建单例，之后的配置依然生效
+func TestObserveError_BeforeInit(t *testing.T) {
+	resetSingleInst(t)
+
+	err := stackerr.New("before init")
+	if fingerprint := ObserveError(err); fingerprint == "" {
+		t.Error("fingerprint should be computed before init")
+	}
+	if internal.LookupSingleInst() != nil {
+		t.Fatal("ObserveError should not create the instance")
+	}
+
+	dir := t.TempDir()
+	GetErrorAggregator(WithDumpDir(dir), WithCrashOutput(false))
+	// 状态文件写在调用者配置的目录中
+	if entries, _ := os.ReadDir(dir); len(entries) == 0 {
+		t.Errorf("dump dir %q should be used", dir)
+	}
+
+	ObserveError(err)
+	if sites := GetErrorAggregator().Sites(); len(sites) != 1 || sites[0].Count != 1 {
+		t.Errorf("sites: got %+v", sites)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/dan-and-dna/dprof
 
-go 1.19
+go 1.23
 
 require (
 	github.com/prometheus/client_golang v1.14.0
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"math"
+	"time"
+)
+
+const (
+	defaultAnomalyZScore = 3                // 超过均值3个标准差
+	defaultAnomalyWindow = time.Hour        // 基线的时间常数
+	defaultAnomalyWarmup = 10 * time.Minute // 学习10分钟后才开始检测
+	maxBaselineStep      = 10 * time.Second // 单次更新最多按10秒计算，避免重启后的长时间间隔冲掉基线
+	baselineSaveInterval = 5 * time.Minute  // 基线持久化的间隔
+	minStddevRatio       = 0.01             // 标准差最小按均值的1%计算，避免几乎不变的指标稍有波动就触发
+)
+
+// Anomaly 基线异常检测，学习指标的EWMA均值和方差，当前值的z-score超过阈值就抓取剖析，适合负载随时间变化很大的服务
+type Anomaly struct {
+	Name      string                        // 名字，也是dump文件的tag和持久化基线的key，不能为空或重复
+	Kinds     []int                         // 抓取的剖析类型
+	Key       int                           // 优先级，为0时使用DumpAnomaly加上在配置中的序号，低于默认规则，每个异常检测有自己的冷却时间
+	Interval  int64                         // 冷却时间，单位秒
+	KeepTime  int64                         // 持续时间，单位秒，只对cpu剖析和执行跟踪有效
+	Value     func(m *stat.Metrics) float64 // 学习的指标
+	ZScore    float64                       // z-score阈值，只检测高于基线的情况，默认3
+	Window    time.Duration                 // 基线的时间常数，越长越平滑，默认1小时
+	Warmup    time.Duration                 // 学习多长时间后才开始检测，默认10分钟
+	HourOfDay bool                          // 按一天中的小时分别学习基线，小时的基线还没学好时使用全天的基线
+}
+
+// ewmaStat 指数加权的均值和方差
+type ewmaStat struct {
+	Mean    float64 `json:"mean"`
+	Var     float64 `json:"var"`
+	Seconds float64 `json:"seconds"` // 已经学习的时长
+}
+
+// update 按时间间隔计算权重，采集间隔变化不影响基线的时间常数
+func (s *ewmaStat) update(value float64, dt, window time.Duration) {
+	if s.Seconds == 0 {
+		s.Mean = value
+		s.Var = 0
+	} else {
+		alpha := 1 - math.Exp(-float64(dt)/float64(window))
+		diff := value - s.Mean
+		incr := alpha * diff
+		s.Mean += incr
+		s.Var = (1 - alpha) * (s.Var + diff*incr)
+	}
+	s.Seconds += dt.Seconds()
+}
+
+// zscore 当前值偏离均值几个标准差
+func (s *ewmaStat) zscore(value float64) float64 {
+	stddev := math.Max(math.Sqrt(s.Var), math.Abs(s.Mean)*minStddevRatio)
+	if stddev == 0 {
+		return 0
+	}
+
+	return (value - s.Mean) / stddev
+}
+
+// baseline 一个指标的基线，会持久化到状态文件中
+type baseline struct {
+	Global ewmaStat   `json:"global"`
+	Hours  []ewmaStat `json:"hours,omitempty"` // 按本地时间的小时
+	Last   int64      `json:"last"`            // 上一次更新的时间，单位ms
+}
+
+// score 返回当前值的z-score，还没学好时返回0
+func (b *baseline) score(anomaly *Anomaly, value float64, now time.Time) float64 {
+	s := &b.Global
+	if anomaly.HourOfDay && len(b.Hours) == 24 && b.Hours[now.Hour()].Seconds >= anomaly.Warmup.Seconds() {
+		s = &b.Hours[now.Hour()]
+	}
+	if s.Seconds < anomaly.Warmup.Seconds() {
+		return 0
+	}
+
+	return s.zscore(value)
+}
+
+// update 更新全天和当前小时的基线
+func (b *baseline) update(anomaly *Anomaly, value float64, now time.Time) {
+	// 第一个值只记录时间
+	last := b.Last
+	b.Last = now.UnixMilli()
+	if last == 0 {
+		return
+	}
+	dt := min(now.Sub(time.UnixMilli(last)), maxBaselineStep)
+	if dt <= 0 {
+		return
+	}
+
+	b.Global.update(value, dt, anomaly.Window)
+	if anomaly.HourOfDay {
+		if len(b.Hours) != 24 {
+			b.Hours = make([]ewmaStat, 24)
+		}
+		b.Hours[now.Hour()].update(value, dt, anomaly.Window)
+	}
+}
+
+// anomalyDetector 一个异常检测，基线在每轮采集后、检查规则前学习，规则的条件只读取算好的z-score
+type anomalyDetector struct {
+	Anomaly
+	value float64
+	z     float64 // 最近一次的z-score，还没学好或值无效时为0
+}
+
+// anomalyRule 把第index个异常检测转换成触发规则，和其他规则走同样的抓取流程；名字为空、重复或者没有指标时忽略
+func (d *dProf) anomalyRule(anomaly Anomaly, index int) (Rule, bool) {
+	// 名字是基线的key，重复时会共用同一个基线
+	_, duplicate := d.baselines[anomaly.Name]
+	if anomaly.Name == "" || duplicate || anomaly.Value == nil {
+		d.logger.Warn("invalid anomaly, ignored", "anomaly", anomaly.Name, "index", index)
+		return Rule{}, false
+	}
+
+	// 默认的优先级不能超过默认规则
+	if anomaly.Key == 0 {
+		anomaly.Key = DumpAnomaly + index
+		if anomaly.Key >= Dump100 {
+			d.logger.Warn("too many anomalies, ignored", "anomaly", anomaly.Name, "index", index)
+			return Rule{}, false
+		}
+	}
+	if anomaly.ZScore <= 0 {
+		anomaly.ZScore = defaultAnomalyZScore
+	}
+	if anomaly.Window <= 0 {
+		anomaly.Window = defaultAnomalyWindow
+	}
+	if anomaly.Warmup <= 0 {
+		anomaly.Warmup = defaultAnomalyWarmup
+	}
+
+	rule := Rule{
+		Name:     anomaly.Name,
+		Kinds:    anomaly.Kinds,
+		Key:      anomaly.Key,
+		Interval: anomaly.Interval,
+		KeepTime: anomaly.KeepTime,
+	}
+
+	detector := &anomalyDetector{Anomaly: anomaly}
+	d.anomalies = append(d.anomalies, detector)
+	d.baselines[anomaly.Name] = &baseline{}
+	rule.Condition = func(m *stat.Metrics) bool {
+		return detector.z >= detector.ZScore
+	}
+
+	return rule, true
+}
+
+// learnBaselines 计算每个异常检测的z-score并学习基线，在检查规则前调用；每隔baselineSaveInterval在锁外持久化一次
+func (d *dProf) learnBaselines(m *stat.Metrics, now time.Time) {
+	if len(d.anomalies) == 0 {
+		return
+	}
+
+	// 用户的Value不在锁内执行
+	for _, detector := range d.anomalies {
+		detector.value = detector.Value(m)
+	}
+
+	var state *profilerState
+	d.mu.Lock()
+	for _, detector := range d.anomalies {
+		detector.z = 0
+		if math.IsNaN(detector.value) || math.IsInf(detector.value, 0) {
+			continue
+		}
+
+		b := d.baselines[detector.Name]
+		// 先打分再学习，异常值不会马上拉高基线
+		detector.z = b.score(&detector.Anomaly, detector.value, now)
+		b.update(&detector.Anomaly, detector.value, now)
+	}
+	if now.Sub(d.lastBaselineSave) >= baselineSaveInterval {
+		d.lastBaselineSave = now
+		state = d.stateLocked()
+	}
+	d.mu.Unlock()
+	d.saveState(state)
+
+	for _, detector := range d.anomalies {
+		d.metrics.anomalyScore.WithLabelValues(detector.Name).Set(detector.z)
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestBaseline(t *testing.T) {
+	anomaly := &Anomaly{Window: time.Minute, Warmup: 30 * time.Second, HourOfDay: true}
+	b := &baseline{}
+
+	// 白天100上下波动
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	for i := 0; i < 120; i++ {
+		if z := b.score(anomaly, 100, now); i < 30 && z != 0 {
+			t.Fatalf("score before warmup: got %v", z)
+		}
+		b.update(anomaly, float64(90+i%3*10), now)
+		now = now.Add(time.Second)
+	}
+
+	if z := b.score(anomaly, 100, now); z < -1 || z > 1 {
+		t.Errorf("normal value: got %v", z)
+	}
+	if z := b.score(anomaly, 200, now); z < defaultAnomalyZScore {
+		t.Errorf("spike: got %v", z)
+	}
+
+	// 小时的基线还没学好时使用全天的基线
+	night := now.Add(12 * time.Hour)
+	if z := b.score(anomaly, 200, night); z < defaultAnomalyZScore {
+		t.Errorf("fallback to global: got %v", z)
+	}
+
+	// 重启后的长时间间隔只按maxBaselineStep计算
+	b.update(anomaly, 1000, night)
+	if b.Global.Mean > 300 {
+		t.Errorf("mean after long gap: got %v", b.Global.Mean)
+	}
+}
+
+func TestAnomalyRule(t *testing.T) {
+	d := &dProf{options: Options{DumpDir: t.TempDir()}, logger: slog.Default(), baselines: make(map[string]*baseline)}
+	d.metrics, _ = newSelfMetrics("test", nil, d.options.DumpDir)
+
+	value := func(m *stat.Metrics) float64 { return float64(m.CpuUsage) }
+	cpu, _ := d.anomalyRule(Anomaly{Name: "cpu", Kinds: []int{DumpCPU}, Value: value, Window: time.Minute, Warmup: time.Nanosecond}, 0)
+	heap, _ := d.anomalyRule(Anomaly{Name: "heap", Kinds: []int{DumpMEM}, Value: value}, 1)
+
+	// 每个异常检测有自己的优先级，冷却时间互不影响，也不会重置默认规则的冷却时间
+	if cpu.Key != DumpAnomaly || heap.Key != DumpAnomaly+1 {
+		t.Errorf("got keys %d, %d", cpu.Key, heap.Key)
+	}
+	for _, rule := range DefaultRules() {
+		if rule.Key <= heap.Key {
+			t.Errorf("anomaly key %d outranks default rule %s(%d)", heap.Key, rule.Name, rule.Key)
+		}
+	}
+
+	// 名字为空、重复和序号超出范围的忽略
+	for i, anomaly := range []Anomaly{{Value: value}, {Name: "cpu", Value: value}, {Name: "late", Value: value}} {
+		index := 2 + i
+		if anomaly.Name == "late" {
+			index = Dump100 - DumpAnomaly
+		}
+		if _, ok := d.anomalyRule(anomaly, index); ok {
+			t.Errorf("anomaly %d(%q) should be ignored", index, anomaly.Name)
+		}
+	}
+	if len(d.anomalies) != 2 || len(d.baselines) != 2 {
+		t.Errorf("got %d anomalies, %d baselines", len(d.anomalies), len(d.baselines))
+	}
+
+	// 条件只读取学习时算好的z-score，多次检查结果不变
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		d.learnBaselines(&stat.Metrics{CpuUsage: int64(100 + i%2)}, now)
+		now = now.Add(time.Second)
+	}
+	m := &stat.Metrics{CpuUsage: 1000}
+	d.learnBaselines(m, now)
+	if !cpu.Condition(m) || !cpu.Condition(m) {
+		t.Error("spike should trigger")
+	}
+	if heap.Condition(m) {
+		t.Error("heap baseline is still warming up")
+	}
+}
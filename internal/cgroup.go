@@ -1,11 +1,12 @@
 package internal
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"github.com/dan-and-dna/dprof/stat"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 )
 
@@ -16,11 +17,33 @@ var (
 	ErrorNoCpuDir      = errors.New("no cpu dir")
 )
 
+// Cgroup 当前进程所属的cgroup，读取文件时复用缓冲区，不能并发使用
 type Cgroup struct {
 	cgroupProcPath string
 	cgroupFSPath   string
 
-	data map[string]string
+	data    map[string]string
+	unified string // v2的目录，混合模式下v1的控制器优先
+
+	files  cgroupFiles
+	reader procReader
+}
+
+// cgroupFiles Init时确定的文件路径，控制器不存在时为空
+type cgroupFiles struct {
+	usage   string // v1的cpuacct.usage
+	quota   string // v1的cpu.cfs_quota_us
+	period  string // v1的cpu.cfs_period_us
+	cpuMax  string // v2的cpu.max
+	cpuStat string // v1或v2的cpu.stat
+	cpus    string // v1的cpuset.cpus或v2的cpuset.cpus.effective
+}
+
+// CpuStat cgroup的cpu.stat，都是累计值
+type CpuStat struct {
+	Periods          uint64 // 经过的时间周期数
+	ThrottledPeriods uint64 // 被限流的时间周期数
+	ThrottledUs      uint64 // 被限流的总时间，单位us
 }
 
 func NewCgroup() *Cgroup {
@@ -31,9 +54,9 @@ func NewCgroup() *Cgroup {
 }
 
 /*
-Init 获得当前进程所处的cgroup信息，目前只支持v1版本的cgroup
+Init 获得当前进程所处的cgroup信息，v1的cpu、cpuacct、cpuset控制器，以及v2的目录
 
-	cgroup 文件的一般格式
+	cgroup 文件的一般格式，v2只有 0::/ 一行
 
 	10:cpuset:/
 	9:pids:/system.slice/tuned.service
@@ -57,10 +80,14 @@ func (c *Cgroup) Init() error {
 	}
 
 	for _, line := range lines {
-		cols := strings.Split(line, ":")
+		cols := strings.SplitN(line, ":", 3)
 		if len(cols) != 3 {
 			return ErrorBadCgroupInfo
 		}
+		if cols[0] == "0" && cols[1] == "" {
+			c.unified = c.unifiedPath(cols[2])
+			continue
+		}
 		if !strings.HasPrefix(cols[1], "cpu") {
 			continue
 		}
@@ -70,73 +97,177 @@ func (c *Cgroup) Init() error {
 			c.data[dirName] = path.Join(c.cgroupFSPath, dirName)
 		}
 	}
+	c.initFiles()
 
 	return nil
 }
 
+// initFiles 确定采集时读取的文件，采集时不再拼接路径
+func (c *Cgroup) initFiles() {
+	files := cgroupFiles{}
+	if dir, ok := c.data["cpuacct"]; ok {
+		files.usage = path.Join(dir, "cpuacct.usage")
+	}
+	if dir, ok := c.data["cpu"]; ok {
+		files.quota = path.Join(dir, "cpu.cfs_quota_us")
+		files.period = path.Join(dir, "cpu.cfs_period_us")
+		files.cpuStat = path.Join(dir, "cpu.stat")
+	} else if c.unified != "" {
+		files.cpuMax = path.Join(c.unified, "cpu.max")
+		files.cpuStat = path.Join(c.unified, "cpu.stat")
+	}
+	if dir, ok := c.data["cpuset"]; ok {
+		files.cpus = path.Join(dir, "cpuset.cpus")
+	} else if c.unified != "" {
+		files.cpus = path.Join(c.unified, "cpuset.cpus.effective")
+	}
+	c.files = files
+}
+
+// unifiedPath v2挂载在/sys/fs/cgroup，混合模式下挂载在/sys/fs/cgroup/unified
+func (c *Cgroup) unifiedPath(cgroupPath string) string {
+	if _, err := os.Stat(path.Join(c.cgroupFSPath, "cgroup.controllers")); err == nil {
+		return path.Join(c.cgroupFSPath, cgroupPath)
+	}
+	if _, err := os.Stat(path.Join(c.cgroupFSPath, "unified", "cgroup.controllers")); err == nil {
+		return path.Join(c.cgroupFSPath, "unified", cgroupPath)
+	}
+
+	return ""
+}
+
 func (cgroup *Cgroup) GetUsage() (uint64, error) {
-	basePath, ok := cgroup.data["cpuacct"]
-	if !ok {
+	if cgroup.files.usage == "" {
 		return 0, ErrorNoCpuacctDir
 	}
 
-	fullPath := path.Join(basePath, "cpuacct.usage")
+	return cgroup.readUint(cgroup.files.usage)
+}
 
-	line, err := ReadLine(fullPath)
-	if err != nil {
-		return 0, err
+// GetQuotaUs 获取当前进程所属的cgroup的每个时间周期可使用的cpu时间数，单位us，-1代表全部cpu时间数
+func (cgroup *Cgroup) GetQuotaUs() (int64, error) {
+	if cgroup.files.quota == "" {
+		quota, _, err := cgroup.getCpuMax()
+		return quota, err
 	}
 
-	val, err := strconv.ParseUint(line, 10, 64)
+	data, err := cgroup.reader.read(cgroup.files.quota)
 	if err != nil {
 		return 0, err
 	}
 
-	return val, nil
-}
+	field, _ := stat.NextField(data)
+	if string(field) == "-1" {
+		return -1, nil
+	}
 
-// GetQuotaUs 获取当前进程所属的cgroup的每个时间周期可使用的cpu时间数，单位us，-1代表全部cpu时间数
-func (cgroup *Cgroup) GetQuotaUs() (int64, error) {
-	basePath, ok := cgroup.data["cpu"]
+	val, ok := stat.ParseUint(field)
 	if !ok {
-		return 0, ErrorNoCpuDir
+		return 0, ErrorBadCgroupInfo
 	}
 
-	fullPath := path.Join(basePath, "cpu.cfs_quota_us")
+	return int64(val), nil
+}
 
-	line, err := ReadLine(fullPath)
-	if err != nil {
-		return 0, err
+// GetPeriodUs 获取当前进程所属的cgroup的时间周期，能使用的cpu核心数=cpu时间数/时间周期，单位us
+func (cgroup *Cgroup) GetPeriodUs() (uint64, error) {
+	if cgroup.files.period == "" {
+		_, period, err := cgroup.getCpuMax()
+		return period, err
 	}
 
-	val, err := strconv.ParseInt(line, 10, 64)
+	return cgroup.readUint(cgroup.files.period)
+}
+
+// readUint 读取只有一个整数的文件
+func (cgroup *Cgroup) readUint(filename string) (uint64, error) {
+	data, err := cgroup.reader.read(filename)
 	if err != nil {
 		return 0, err
 	}
 
+	field, _ := stat.NextField(data)
+	val, ok := stat.ParseUint(field)
+	if !ok {
+		return 0, ErrorBadCgroupInfo
+	}
+
 	return val, nil
 }
 
-// GetPeriodUs 获取当前进程所属的cgroup的时间周期，能使用的cpu核心数=cpu时间数/时间周期，单位us
-func (cgroup *Cgroup) GetPeriodUs() (uint64, error) {
-	basePath, ok := cgroup.data["cpu"]
+/*
+getCpuMax 读取v2的cpu.max，quota为max时返回-1
+
+	max 100000
+	50000 100000
+*/
+func (cgroup *Cgroup) getCpuMax() (int64, uint64, error) {
+	if cgroup.files.cpuMax == "" {
+		return 0, 0, ErrorNoCpuDir
+	}
+
+	data, err := cgroup.reader.read(cgroup.files.cpuMax)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	quotaField, rest := stat.NextField(data)
+	periodField, rest := stat.NextField(rest)
+	if extra, _ := stat.NextField(rest); len(periodField) == 0 || len(extra) != 0 {
+		return 0, 0, ErrorBadCgroupInfo
+	}
+
+	var quota int64 = -1
+	if string(quotaField) != "max" {
+		val, ok := stat.ParseUint(quotaField)
+		if !ok {
+			return 0, 0, ErrorBadCgroupInfo
+		}
+		quota = int64(val)
+	}
+
+	period, ok := stat.ParseUint(periodField)
 	if !ok {
-		return 0, ErrorNoCpuDir
+		return 0, 0, ErrorBadCgroupInfo
 	}
 
-	fullPath := path.Join(basePath, "cpu.cfs_period_us")
+	return quota, period, nil
+}
 
-	line, err := ReadLine(fullPath)
-	if err != nil {
-		return 0, err
+// GetCpuStat 获取当前进程所属的cgroup的限流情况，v1的throttled_time单位为ns，v2的throttled_usec单位为us
+func (cgroup *Cgroup) GetCpuStat() (CpuStat, error) {
+	if cgroup.files.cpuStat == "" {
+		return CpuStat{}, ErrorNoCpuDir
 	}
 
-	val, err := strconv.ParseUint(line, 10, 64)
+	data, err := cgroup.reader.read(cgroup.files.cpuStat)
 	if err != nil {
-		return 0, err
+		return CpuStat{}, err
 	}
 
-	return val, nil
+	periods, ok := lookupUint(data, "nr_periods")
+	throttledPeriods, _ := lookupUint(data, "nr_throttled")
+	// v1
+	if cgroup.files.quota != "" {
+		throttledNs, _ := lookupUint(data, "throttled_time")
+		return CpuStat{
+			Periods:          periods,
+			ThrottledPeriods: throttledPeriods,
+			ThrottledUs:      throttledNs / 1000,
+		}, nil
+	}
+
+	// v2没有开启cpu控制器时只有usage_usec等
+	if !ok {
+		return CpuStat{}, ErrorNoCpuDir
+	}
+
+	throttledUs, _ := lookupUint(data, "throttled_usec")
+	return CpuStat{
+		Periods:          periods,
+		ThrottledPeriods: throttledPeriods,
+		ThrottledUs:      throttledUs,
+	}, nil
 }
 
 /*
@@ -144,52 +275,65 @@ GetCpus 获取当前进程所属的cgroup可使用的cpu核心编号，所谓亲
 格式为 0-3,6
 */
 func (cgroup *Cgroup) GetCpus() ([]uint64, error) {
-	basePath, ok := cgroup.data["cpuset"]
-	if !ok {
-		return nil, ErrorBadCPUStat
+	var cpus []uint64
+	err := cgroup.rangeCpus(func(min, max uint64) {
+		for i := min; i <= max; i++ {
+			cpus = append(cpus, i)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	fullPath := path.Join(basePath, "cpuset.cpus")
+	return cpus, nil
+}
+
+// GetCpuCount 获取当前进程所属的cgroup可使用的cpu核心数，不生成核心编号列表
+func (cgroup *Cgroup) GetCpuCount() (int, error) {
+	count := 0
+	err := cgroup.rangeCpus(func(min, max uint64) {
+		count += int(max-min) + 1
+	})
 
-	line, err := ReadLine(fullPath)
+	return count, err
+}
+
+// rangeCpus 遍历cpuset中的每一段编号，单独的编号min和max相同；内核输出的列表有序不重叠
+func (cgroup *Cgroup) rangeCpus(fn func(min, max uint64)) error {
+	if cgroup.files.cpus == "" {
+		return ErrorNoCpusetDir
+	}
+
+	data, err := cgroup.reader.read(cgroup.files.cpus)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	list := make(map[uint64]struct{})
-	cpuIdRanges := strings.Split(line, ",")
-	for _, cpuIdRange := range cpuIdRanges {
-		if strings.Contains(cpuIdRange, "-") {
-			// cpu编号范围
-			cpuIds := strings.SplitN(cpuIdRange, "-", 2)
-			min, err := strconv.ParseUint(cpuIds[0], 10, 64)
-			if err != nil {
-				return nil, err
-			}
-
-			max, err := strconv.ParseUint(cpuIds[1], 10, 64)
-			if err != nil {
-				return nil, err
-			}
-
-			for i := min; i <= max; i++ {
-				list[i] = struct{}{}
-			}
+	line, _ := stat.NextField(data)
+	for len(line) > 0 {
+		var cpuIdRange []byte
+		if i := bytes.IndexByte(line, ','); i >= 0 {
+			cpuIdRange, line = line[:i], line[i+1:]
 		} else {
-			// 单独的cpu编号
-			v, err := strconv.ParseUint(cpuIdRange, 10, 64)
-			if err != nil {
-				return nil, err
-			}
+			cpuIdRange, line = line, nil
+		}
 
-			list[v] = struct{}{}
+		minField, maxField := cpuIdRange, cpuIdRange
+		if i := bytes.IndexByte(cpuIdRange, '-'); i >= 0 {
+			minField, maxField = cpuIdRange[:i], cpuIdRange[i+1:]
 		}
-	}
 
-	var cpus []uint64
-	for k := range list {
-		cpus = append(cpus, k)
+		min, ok := stat.ParseUint(minField)
+		if !ok {
+			return ErrorBadCgroupInfo
+		}
+		max, ok := stat.ParseUint(maxField)
+		if !ok || max < min {
+			return ErrorBadCgroupInfo
+		}
+
+		fn(min, max)
 	}
 
-	return cpus, nil
+	return nil
 }
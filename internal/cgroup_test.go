@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestCgroup_V1(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"proc":                     "3:cpuset:/\n2:cpu,cpuacct:/\n0::/\n",
+		"fs/cpu/cpu.stat":          "nr_periods 100\nnr_throttled 20\nthrottled_time 3000000\n",
+		"fs/cpu/cpu.cfs_quota_us":  "50000\n",
+		"fs/cpu/cpu.cfs_period_us": "100000\n",
+		"fs/cpuset/cpuset.cpus":    "0-3,6\n",
+	})
+
+	cgroup := &Cgroup{cgroupProcPath: filepath.Join(root, "proc"), cgroupFSPath: filepath.Join(root, "fs")}
+	if err := cgroup.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	cpuStat, err := cgroup.GetCpuStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpuStat != (CpuStat{Periods: 100, ThrottledPeriods: 20, ThrottledUs: 3000}) {
+		t.Errorf("got %+v", cpuStat)
+	}
+
+	quota, err := cgroup.GetQuotaUs()
+	if err != nil || quota != 50000 {
+		t.Errorf("got quota %d, %v", quota, err)
+	}
+
+	cpus, err := cgroup.GetCpus()
+	if err != nil || len(cpus) != 5 {
+		t.Errorf("got cpus %v, %v", cpus, err)
+	}
+
+	count, err := cgroup.GetCpuCount()
+	if err != nil || count != 5 {
+		t.Errorf("got cpu count %d, %v", count, err)
+	}
+
+	// 采集时不分配内存
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = cgroup.GetCpuStat()
+		_, _ = cgroup.GetQuotaUs()
+		_, _ = cgroup.GetCpuCount()
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs", allocs)
+	}
+}
+
+func TestCgroup_V2(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"proc":                         "0::/app\n",
+		"fs/cgroup.controllers":        "cpu cpuset memory\n",
+		"fs/app/cpu.stat":              "usage_usec 1000\nnr_periods 50\nnr_throttled 10\nthrottled_usec 2000\n",
+		"fs/app/cpu.max":               "max 100000\n",
+		"fs/app/cpuset.cpus.effective": "0-1\n",
+	})
+
+	cgroup := &Cgroup{cgroupProcPath: filepath.Join(root, "proc"), cgroupFSPath: filepath.Join(root, "fs")}
+	if err := cgroup.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	cpuStat, err := cgroup.GetCpuStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpuStat != (CpuStat{Periods: 50, ThrottledPeriods: 10, ThrottledUs: 2000}) {
+		t.Errorf("got %+v", cpuStat)
+	}
+
+	quota, err := cgroup.GetQuotaUs()
+	if err != nil || quota != -1 {
+		t.Errorf("got quota %d, %v", quota, err)
+	}
+
+	period, err := cgroup.GetPeriodUs()
+	if err != nil || period != 100000 {
+		t.Errorf("got period %d, %v", period, err)
+	}
+
+	cpus, err := cgroup.GetCpus()
+	if err != nil || len(cpus) != 2 {
+		t.Errorf("got cpus %v, %v", cpus, err)
+	}
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"time"
+)
+
+// CgroupCpuSampler 采集cgroup的cpu配额和限流情况，容器用满配额被限流是很多延迟毛刺的真正原因
+type CgroupCpuSampler struct {
+	cgroup  *Cgroup
+	prev    CpuStat
+	hasPrev bool
+}
+
+// NewCgroupCpuSampler 当前进程不在cgroup中或没有cpu控制器时返回错误
+func NewCgroupCpuSampler() (*CgroupCpuSampler, error) {
+	cgroup := NewCgroup()
+	if err := cgroup.Init(); err != nil {
+		return nil, err
+	}
+
+	if _, err := cgroup.GetCpuStat(); err != nil {
+		return nil, err
+	}
+
+	return &CgroupCpuSampler{cgroup: cgroup}, nil
+}
+
+func (s *CgroupCpuSampler) Name() string {
+	return "cgroup_cpu"
+}
+
+// Sample 配额可能被动态调整，每次都重新读取
+func (s *CgroupCpuSampler) Sample(m *stat.Metrics) error {
+	cpuStat, err := s.cgroup.GetCpuStat()
+	if err != nil {
+		return err
+	}
+
+	cgroupCpu := stat.CgroupCpu{
+		Available:        true,
+		Periods:          cpuStat.Periods,
+		ThrottledPeriods: cpuStat.ThrottledPeriods,
+		ThrottledSeconds: float64(cpuStat.ThrottledUs) / 1e6,
+	}
+
+	if quota, err := s.cgroup.GetQuotaUs(); err == nil {
+		period, err := s.cgroup.GetPeriodUs()
+		if err == nil && period > 0 {
+			cgroupCpu.PeriodSeconds = float64(time.Duration(period)*time.Microsecond) / float64(time.Second)
+			if quota > 0 {
+				cgroupCpu.QuotaCores = float64(quota) / float64(period)
+			}
+		}
+	}
+
+	if cpus, err := s.cgroup.GetCpuCount(); err == nil {
+		cgroupCpu.CpusetCpus = cpus
+	}
+
+	// 两次采样之间被限流的时间周期比例
+	if prev := s.prev; s.hasPrev && cpuStat.Periods > prev.Periods && cpuStat.ThrottledPeriods >= prev.ThrottledPeriods {
+		cgroupCpu.ThrottledRatio = float64(cpuStat.ThrottledPeriods-prev.ThrottledPeriods) / float64(cpuStat.Periods-prev.Periods)
+	}
+	s.prev, s.hasPrev = cpuStat, true
+
+	m.CgroupCpu = cgroupCpu
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dan-and-dna/dprof/stackerr"
+	"os"
+	"runtime/pprof"
+	"time"
+)
+
+// OnPanic 处理recover到的panic，输出崩溃报告，开启Repanic时会再次panic
+func (d *dProf) OnPanic(value interface{}) {
+	var err error
+	if e, ok := value.(error); ok {
+		err = stackerr.Wrap(e, "panic")
+	} else {
+		err = stackerr.Errorf("panic: %v", value)
+	}
+
+	d.errors.Observe(err)
+	d.writeCrashReport("panic", err)
+
+	if d.options.Repanic {
+		panic(value)
+	}
+}
+
+/*
+writeCrashReport 输出崩溃报告，包括错误和调用栈、当前的指标、所有协程的调用栈
+
+	crash: panic
+	time: 2006-01-02T15:04:05Z07:00
+	pid: 1234
+
+	== error ==
+	...
+	== metrics ==
+	...
+	== goroutines ==
+	...
+*/
+func (d *dProf) writeCrashReport(tag string, err error) {
+	kind := "crash"
+	start := time.Now()
+
+	d.logger.Error("crash", "tag", tag, "err", err)
+
+	f, createErr := d.createDumpFileWithExt(fmt.Sprintf("%s-%s", kind, tag), "txt")
+	if createErr != nil {
+		d.logger.Error("create dump file failed", "kind", kind, "tag", tag, "err", createErr)
+		d.metrics.captureDone(kind, tag, start, 0, createErr)
+		return
+	}
+
+	_, _ = fmt.Fprintf(f, "crash: %s\ntime: %s\npid: %d\n\n", tag, start.Format(time.RFC3339), os.Getpid())
+
+	_, _ = fmt.Fprintf(f, "== error ==\n%+v\n\n", err)
+
+	_, _ = fmt.Fprintf(f, "== metrics ==\n")
+	metrics, _ := json.MarshalIndent(d.stat.Snapshot(), "", "  ")
+	_, _ = f.Write(metrics)
+	_, _ = fmt.Fprintf(f, "\n\n")
+
+	_, _ = fmt.Fprintf(f, "== goroutines ==\n")
+	writeErr := pprof.Lookup("goroutine").WriteTo(f, 2)
+
+	d.closeDumpFile(f, kind, tag, start, writeErr)
+
+	d.mu.Lock()
+	d.lastCrashReport = f.Name()
+	state := d.stateLocked()
+	d.mu.Unlock()
+	d.saveState(state)
+}
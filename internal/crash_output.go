@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	crashOutputHeader = "pid: "         // 崩溃输出文件的第一行，记录写入时的进程号
+	crashOutputExt    = "crash.log"     // 崩溃输出文件的后缀
+	crashOutputTmpExt = "crash.tmp"     // 还没有写入进程号的崩溃输出文件的后缀
+	crashOutputMinAge = 5 * time.Second // 没有进程号的文件至少存在这么久才删除，避免删掉其他副本刚创建的文件
+)
+
+/*
+setupCrashOutput 把没有恢复的panic和运行时致命错误(concurrent map writes, out of memory)的输出重定向到dump目录
+
+ 1. 已经没有进程在使用的崩溃输出文件中有内容，说明那次运行崩溃了，重命名为带时间的崩溃报告，没有内容的删除
+ 2. 用临时文件名创建当前进程的崩溃输出文件，加锁并写入当前进程号后再换成带进程号和启动时间的文件名，注册到debug.SetCrashOutput
+
+同一个目录中的多个副本各自写自己的文件，通过文件锁判断文件是否还在使用，pid namespace不同时也不会误判
+*/
+func (d *dProf) setupCrashOutput() {
+	d.rotateCrashOutputs()
+
+	err := os.MkdirAll(d.options.DumpDir, 0755)
+	if err != nil {
+		d.logger.Error("create crash output failed", "dir", d.options.DumpDir, "err", err)
+		return
+	}
+
+	f, crashPath, err := d.createCrashOutput()
+	if err != nil {
+		d.metrics.dumpFileErrors.Inc()
+		d.logger.Error("create crash output failed", "dir", d.options.DumpDir, "err", err)
+		return
+	}
+	// SetCrashOutput 会复制文件描述符，可以直接关闭，复制的描述符共享同一把锁，进程退出时才释放
+	defer f.Close()
+
+	err = debug.SetCrashOutput(f, debug.CrashOptions{})
+	if err != nil {
+		d.logger.Error("set crash output failed", "path", crashPath, "err", err)
+	}
+}
+
+// createCrashOutput 创建当前进程的崩溃输出文件 程序名-进程号-启动时间.crash.log
+//
+// 先用临时文件名创建，加锁并写入进程号后再链接到正式的文件名，其他副本轮转时不会看到没有加锁或者没有进程号的文件
+func (d *dProf) createCrashOutput() (*os.File, string, error) {
+	prefix := fmt.Sprintf("%s-%d-%s", getAppName(), os.Getpid(), time.Now().Format("2006-01-02_15-04-05"))
+	tmpPath := filepath.Join(d.options.DumpDir, fmt.Sprintf("%s.%s", prefix, crashOutputTmpExt))
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		d.logger.Warn("lock crash output failed", "path", tmpPath, "err", err)
+	}
+
+	_, err = fmt.Fprintf(f, "%s%d\n", crashOutputHeader, os.Getpid())
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return nil, "", err
+	}
+
+	crashPath := filepath.Join(d.options.DumpDir, fmt.Sprintf("%s.%s", prefix, crashOutputExt))
+	published, err := publishCrashOutput(f, crashPath)
+	for i := 1; os.IsExist(err) && i < 100; i++ {
+		crashPath = filepath.Join(d.options.DumpDir, fmt.Sprintf("%s-%d.%s", prefix, i, crashOutputExt))
+		published, err = publishCrashOutput(f, crashPath)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return nil, "", err
+	}
+
+	return published, crashPath, nil
+}
+
+// isCrashOutput 是否是当前程序的崩溃输出文件，包括旧版本不带进程号的 程序名-crash.log
+func isCrashOutput(name string) bool {
+	prefix := getAppName() + "-"
+	if name == prefix+"crash.log" {
+		return true
+	}
+
+	return isCrashOutputFile(name, crashOutputExt)
+}
+
+// isCrashOutputFile 是否是当前程序 程序名-进程号-*.后缀 格式的文件
+func isCrashOutputFile(name, ext string) bool {
+	prefix := getAppName() + "-"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "."+ext) {
+		return false
+	}
+
+	// 程序名后面是进程号，避免匹配到名字以当前程序名开头的其他程序
+	pid, _, ok := strings.Cut(strings.TrimPrefix(name, prefix), "-")
+	_, err := strconv.Atoi(pid)
+
+	return ok && err == nil
+}
+
+// rotateCrashOutputs 轮转dump目录中其他进程留下的崩溃输出文件，最新的崩溃报告作为上一次运行的崩溃报告
+func (d *dProf) rotateCrashOutputs() {
+	entries, err := os.ReadDir(d.options.DumpDir)
+	if err != nil {
+		return
+	}
+
+	var lastModTime time.Time
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if isCrashOutputFile(entry.Name(), crashOutputTmpExt) {
+			d.removeCrashOutputTmp(filepath.Join(d.options.DumpDir, entry.Name()))
+			continue
+		}
+		if !isCrashOutput(entry.Name()) {
+			continue
+		}
+
+		crashPath := filepath.Join(d.options.DumpDir, entry.Name())
+		reportPath, modTime, err := d.rotateCrashOutput(crashPath)
+		if err != nil {
+			d.logger.Error("rotate crash output failed", "path", crashPath, "err", err)
+			continue
+		}
+		if reportPath == "" {
+			continue
+		}
+
+		d.metrics.previousRunCrashed.Set(1)
+		d.logger.Warn("previous run crashed", "path", reportPath)
+		if modTime.After(lastModTime) {
+			lastModTime = modTime
+			d.lastCrashReport = reportPath
+		}
+	}
+}
+
+// rotateCrashOutput 没有进程在使用的崩溃输出文件除了第一行还有内容时，重命名为崩溃报告，返回崩溃报告的路径和崩溃的时间；
+// 没有内容的直接删除，还在使用的不处理
+func (d *dProf) rotateCrashOutput(crashPath string) (string, time.Time, error) {
+	// 同一个目录中的其他副本正在使用
+	if crashOutputInUse(crashPath) {
+		return "", time.Time{}, nil
+	}
+
+	info, err := os.Stat(crashPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	pid, headerLen, err := readCrashOutputHeader(crashPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// 只有进程号，没有崩溃；没有进程号的空文件可能是其他副本刚创建的，等一会再删
+	if info.Size() <= headerLen {
+		if headerLen == 0 && time.Since(info.ModTime()) < crashOutputMinAge {
+			return "", time.Time{}, nil
+		}
+		return "", time.Time{}, os.Remove(crashPath)
+	}
+
+	// 多个副本的进程号可能相同，不覆盖已有的崩溃报告
+	prefix := fmt.Sprintf("%s-%d-crash-fatal-%s", getAppName(), pid, info.ModTime().Format("2006-01-02_15-04-05"))
+	reportPath := filepath.Join(d.options.DumpDir, prefix+".txt")
+	for i := 1; i < 100; i++ {
+		if _, err := os.Stat(reportPath); os.IsNotExist(err) {
+			break
+		}
+		reportPath = filepath.Join(d.options.DumpDir, fmt.Sprintf("%s-%d.txt", prefix, i))
+	}
+
+	err = os.Rename(crashPath, reportPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return reportPath, info.ModTime(), nil
+}
+
+// removeCrashOutputTmp 删除创建过程中进程退出留下的临时文件，其他副本正在创建的不处理
+func (d *dProf) removeCrashOutputTmp(tmpPath string) {
+	if crashOutputInUse(tmpPath) {
+		return
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil || time.Since(info.ModTime()) < crashOutputMinAge {
+		return
+	}
+
+	err = os.Remove(tmpPath)
+	if err != nil {
+		d.logger.Error("remove crash output failed", "path", tmpPath, "err", err)
+	}
+}
+
+// readCrashOutputHeader 读取崩溃输出文件第一行的进程号，返回进程号和第一行的长度
+func readCrashOutputHeader(crashPath string) (int, int64, error) {
+	f, err := os.Open(crashPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	if !strings.HasPrefix(line, crashOutputHeader) {
+		// 不是dprof写入的格式，整个文件都当作崩溃输出
+		return 0, 0, nil
+	}
+
+	pid, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, crashOutputHeader)))
+	return pid, int64(len(line)), nil
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateCrashOutputs(t *testing.T) {
+	dir := t.TempDir()
+	d := &dProf{options: Options{DumpDir: dir}, logger: slog.Default()}
+	d.metrics, _ = newSelfMetrics("test", nil, dir)
+
+	app := getAppName()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// 其他副本正在使用，进程号和当前进程相同也不能动
+	live := write(fmt.Sprintf("%s-%d-2024-01-01_00-00-00.crash.log", app, os.Getpid()), fmt.Sprintf("pid: %d\nfatal error: running\n", os.Getpid()))
+	f, err := os.Open(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	crashed := write(app+"-1-2024-01-01_00-00-00.crash.log", "pid: 1\nfatal error: concurrent map writes\n")
+	clean := write(app+"-2-2024-01-01_00-00-00.crash.log", "pid: 2\n")
+	legacy := write(app+"-crash.log", "pid: 3\nfatal error: out of memory\n")
+	other := write(app+"-other-1-2024-01-01_00-00-00.crash.log", "pid: 1\nfatal error: other app\n")
+
+	// 没有进程号的空文件和临时文件，刚创建的保留，旧的删除
+	old := time.Now().Add(-time.Minute)
+	young := write(app+"-4-2024-01-01_00-00-00.crash.log", "")
+	youngTmp := write(app+"-5-2024-01-01_00-00-00.crash.tmp", "")
+	stale := write(app+"-6-2024-01-01_00-00-00.crash.log", "")
+	staleTmp := write(app+"-7-2024-01-01_00-00-00.crash.tmp", "pid: 7\n")
+	for _, path := range []string{stale, staleTmp} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.rotateCrashOutputs()
+
+	for _, path := range []string{live, other, young, youngTmp} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s should be kept: %v", path, err)
+		}
+	}
+	for _, path := range []string{crashed, clean, legacy, stale, staleTmp} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should be rotated", path)
+		}
+	}
+
+	reports, _ := filepath.Glob(filepath.Join(dir, app+"-*-crash-fatal-*.txt"))
+	if len(reports) != 2 || d.lastCrashReport == "" {
+		t.Errorf("reports: got %v, last: %q", reports, d.lastCrashReport)
+	}
+}
+
+func TestCreateCrashOutput(t *testing.T) {
+	dir := t.TempDir()
+	d := &dProf{options: Options{DumpDir: dir}, logger: slog.Default()}
+
+	f, crashPath, err := d.createCrashOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := os.ReadFile(crashPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("pid: %d\n", os.Getpid()); string(data) != want {
+		t.Errorf("header: got %q, want %q", data, want)
+	}
+	if !crashOutputInUse(crashPath) {
+		t.Error("crash output should be locked")
+	}
+
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*."+crashOutputTmpExt))
+	if len(tmps) != 0 {
+		t.Errorf("temp files left: %v", tmps)
+	}
+}
@@ -2,18 +2,21 @@ package internal
 
 import (
 	"fmt"
+	"github.com/dan-and-dna/dprof/stackerr"
 	"github.com/dan-and-dna/dprof/stat"
 	"github.com/prometheus/client_golang/prometheus"
-	"log"
+	"log/slog"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"runtime/trace"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	singleInst *dProf = nil
+	singleInst atomic.Pointer[dProf]
 	once       sync.Once
 )
 
@@ -22,6 +25,11 @@ const (
 	DumpSignal = iota + 1000
 	DumpCPU
 	DumpMEM
+	DumpGoroutine
+	DumpTrace
+	DumpFd
+
+	DumpAnomaly = 10 // 偏离基线，多个异常检测依次加1，优先级低于所有默认规则，抓取时不会重置默认规则的冷却时间
 
 	Dump100 = 100
 	Dump200 = 200
@@ -33,61 +41,182 @@ const (
 	Dump800 = 800
 	Dump900 = 900
 
+	DumpSchedLatency = 910 // 调度延迟过高
+	DumpGCCpu        = 920 // gc占用cpu过高
+	DumpCrashLoop    = 930 // 崩溃循环
+	DumpCpuThrottled = 940 // cgroup限流
+	DumpMemPressure  = 950 // 内存压力
+	DumpFdExhaustion = 960 // fd快用完了
+	DumpThreads      = 970 // 系统线程暴涨
+	DumpFdLeak       = 980 // fd持续增长
+	DumpCloseWait    = 990 // CLOSE_WAIT持续增长
+
 	DumpEOF = 9999
 )
 
+var dumpKindNames = map[int]string{
+	DumpCPU:       "cpu",
+	DumpMEM:       "heap",
+	DumpGoroutine: "goroutine",
+	DumpTrace:     "trace",
+	DumpFd:        "fd",
+}
+
 type dProf struct {
-	signalChan        chan os.Signal
-	done              chan struct{}
-	isDoingCpuProfile bool
-	isDoingMemProfile bool
-	timers            map[int]map[int]int64
-	stat              *stat.Stat
+	signalChan chan os.Signal
+	done       chan struct{}
+	mu         sync.Mutex
+	isDoing    map[int]bool // 各类剖析是否正在执行
+	timers     map[int]map[int]int64
+	stat       *stat.Stat
+	options    Options
+	metrics    *selfMetrics
+	logger     *slog.Logger
+	errors     *stackerr.Aggregator
+	rules      []Rule
+	ruleStates []ruleState // 和rules一一对应
+	rulesOnce  sync.Once
+
+	hostContention bool // 宿主机是否在争抢cpu，只在采集协程中访问
+
+	anomalies        []*anomalyDetector   // 异常检测，只在采集协程中访问
+	baselines        map[string]*baseline // 异常检测学习到的基线，由mu保护
+	lastBaselineSave time.Time
+
+	// 持久化的状态
+	lastCrashReport string  // 上一次运行崩溃时留下的崩溃报告
+	startTimes      []int64 // 最近几次启动的时间
+	restartCount    int     // 累计重启次数
+	crashLoop       bool    // 是否处于崩溃循环
+	stateSeq        uint64  // 状态快照的序号，由mu保护
+
+	stateFileMu   sync.Mutex // 写状态文件
+	savedStateSeq uint64     // 已经写入的快照序号，由stateFileMu保护
 }
 
-func GetSingleInst() *dProf {
-	if singleInst == nil {
-		once.Do(func() {
-			singleInst = newDProf()
-		})
-	}
+// GetSingleInst 获取单例，opts只在第一次调用时生效
+func GetSingleInst(opts ...Option) *dProf {
+	once.Do(func() {
+		singleInst.Store(newDProf(opts...))
+	})
 
-	return singleInst
+	return singleInst.Load()
+}
+
+// LookupSingleInst 返回已经创建的单例，还没有创建时返回nil，不会用默认配置创建，
+// 供可能早于调用者初始化dprof执行的路径使用，避免调用者的配置被忽略
+func LookupSingleInst() *dProf {
+	return singleInst.Load()
+}
+
+// ResetSingleInst 停止采集并清除单例，之后的GetSingleInst会重新创建，只用于测试，不能和其他调用并发
+func ResetSingleInst() {
+	if d := singleInst.Swap(nil); d != nil {
+		d.stat.Stop()
+	}
+	once = sync.Once{}
 }
-func newDProf() *dProf {
+func newDProf(opts ...Option) *dProf {
+	options := Options{
+		DumpDir:     ".",
+		CrashOutput: true,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	logger := newLogger(options.Logger, options.LogLevel)
+
 	d := &dProf{
-		signalChan:        make(chan os.Signal, 1),
-		done:              make(chan struct{}),
-		isDoingCpuProfile: false,
-		isDoingMemProfile: false,
+		signalChan: make(chan os.Signal, 1),
+		done:       make(chan struct{}),
+		isDoing:    make(map[int]bool),
 		timers: map[int]map[int]int64{
-			DumpCPU: make(map[int]int64),
-			DumpMEM: make(map[int]int64),
+			DumpCPU:       make(map[int]int64),
+			DumpMEM:       make(map[int]int64),
+			DumpGoroutine: make(map[int]int64),
+			DumpTrace:     make(map[int]int64),
+			DumpFd:        make(map[int]int64),
 		},
-		stat: stat.New(),
+		stat:      newStat(logger, options.StatOptions),
+		options:   options,
+		logger:    logger,
+		baselines: make(map[string]*baseline),
+	}
+
+	// dprof自身的指标
+	var collectors []prometheus.Collector
+	d.metrics, collectors = newSelfMetrics(d.stat.Namespace(), d.stat.ConstLabels(), options.DumpDir)
+	d.stat.Registerer().MustRegister(collectors...)
+
+	// 按出错位置统计的错误
+	d.errors = stackerr.NewAggregator(
+		stackerr.WithMetricsNamespace(d.stat.Namespace()),
+		stackerr.WithMetricsConstLabels(d.stat.ConstLabels()),
+	)
+	d.stat.Registerer().MustRegister(d.errors)
+
+	// 触发规则，没有配置时使用默认规则
+	if options.Rules == nil {
+		options.Rules = DefaultRules()
+	}
+	rules := append([]Rule(nil), options.Rules...)
+	for i, anomaly := range options.Anomalies {
+		if rule, ok := d.anomalyRule(anomaly, i); ok {
+			rules = append(rules, rule)
+		}
+	}
+	d.rules = d.validRules(rules)
+	d.ruleStates = make([]ruleState, len(d.rules))
+
+	// 致命错误输出到dump目录
+	if options.CrashOutput {
+		d.setupCrashOutput()
 	}
 
-	// 监控进程指标
-	d.stat.MonitorProcess()
-	// 监控go运行时指标
-	d.stat.MonitorGoRuntime()
+	// 恢复上一次运行的冷却时间和基线，判断是否处于崩溃循环
+	d.loadState()
+
+	// 开始采集进程、运行时和平台相关的指标
+	d.stat.Start()
 
 	return d
 }
 
-// GetStatRegistry 返回当前使用的prometheus registry
+// newStat 平台相关的采集器在前，调用者的配置在后
+func newStat(logger *slog.Logger, opts []stat.Option) *stat.Stat {
+	statOpts := []stat.Option{stat.WithLogger(logger)}
+	statOpts = append(statOpts, platformStatOptions()...)
+	statOpts = append(statOpts, opts...)
+
+	return stat.New(statOpts...)
+}
+
+// GetErrorAggregator 返回注册在dprof指标上的错误统计
+func (d *dProf) GetErrorAggregator() *stackerr.Aggregator {
+	return d.errors
+}
+
+// GetStatRegistry 返回当前使用的prometheus registry，使用调用者的registerer时为nil
 func (d *dProf) GetStatRegistry() *prometheus.Registry {
 	return d.stat.Registry
 }
 
-func (d *dProf) onTimePProf(pprofType, key int, interval, keepTime int64, startPProfFunc func() func()) {
-	// 判断是否已经在执行
-	if pprofType == DumpCPU && d.isDoingCpuProfile {
-		return
-	}
+// onTimePProf 没有在抓取同类剖析且过了冷却时间时开始抓取，返回是否开始了抓取
+func (d *dProf) onTimePProf(pprofType, key int, interval, keepTime int64, startPProfFunc func() func()) bool {
+	// 释放锁后再写状态文件
+	var state *profilerState
+	defer func() {
+		d.saveState(state)
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	if pprofType == DumpMEM && d.isDoingMemProfile {
-		return
+	// 判断是否已经在执行
+	if d.isDoing[pprofType] {
+		d.metrics.skipped.WithLabelValues(dumpKindNames[pprofType], SkipReasonInFlight).Inc()
+		return false
 	}
 
 	timers := d.timers[pprofType]
@@ -102,37 +231,35 @@ func (d *dProf) onTimePProf(pprofType, key int, interval, keepTime int64, startP
 	}
 
 	// 判断时间是否运行
-	if canDump {
-		log.Println("start pprof... ", key)
-
-		// 避免再次启动
-		for k, _ := range timers {
-			if k < key {
-				timers[k] = currentTime
-			}
-		}
+	if !canDump {
+		d.metrics.skipped.WithLabelValues(dumpKindNames[pprofType], SkipReasonCooldown).Inc()
+		return false
+	}
 
-		timers[key] = currentTime
-		if pprofType == DumpCPU {
-			d.isDoingCpuProfile = true
-		}
+	d.logger.Info("start capture", "kind", dumpKindNames[pprofType], "key", key, "keep", time.Duration(keepTime)*time.Second)
 
-		if pprofType == DumpMEM {
-			d.isDoingMemProfile = true
+	// 避免再次启动
+	for k, _ := range timers {
+		if k < key {
+			timers[k] = currentTime
 		}
-
-		stopPProfFunc := startPProfFunc()
-		time.AfterFunc(time.Duration(keepTime)*time.Second, func() {
-			log.Println("pprof stopped ", key)
-			stopPProfFunc()
-			if pprofType == DumpCPU {
-				d.isDoingCpuProfile = true
-			}
-			if pprofType == DumpMEM {
-				d.isDoingMemProfile = true
-			}
-		})
 	}
+
+	timers[key] = currentTime
+	d.isDoing[pprofType] = true
+	state = d.stateLocked()
+
+	stopPProfFunc := startPProfFunc()
+	time.AfterFunc(time.Duration(keepTime)*time.Second, func() {
+		d.logger.Debug("stop capture", "kind", dumpKindNames[pprofType], "key", key)
+		stopPProfFunc()
+
+		d.mu.Lock()
+		d.isDoing[pprofType] = false
+		d.mu.Unlock()
+	})
+
+	return true
 }
 
 /*
@@ -143,68 +270,73 @@ DumpProfiles 输出pprof信息文件
 	2. 抖动超过100，记录一下
 */
 func (d *dProf) DumpProfiles() {
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-
-			// 进程的内存相关剖析 //TODO
-			if d.stat.Metrics.MemUsage >= 100 {
-				d.onTimePProf(DumpMEM, Dump100, 120, 5, func() func() { return d.dumpHeapProfile("normal_gte100") })
-			}
-
-			// 进程cpu相关剖析
-			if d.stat.Metrics.CpuUsageStdDeviation > 50 {
-				// 当前cpu超过100，且抖动厉害，需要单独记录
-				if d.stat.Metrics.CpuUsageStdDeviation >= 100 && d.stat.Metrics.CpuUsage >= 100 {
-					d.onTimePProf(DumpCPU, Dump900, 20, 5, func() func() { return d.dumpCpuProfile("odd_gte100") })
-				}
-
-			} else {
-				// 定位负载
-				if d.stat.Metrics.CpuUsage <= 100 {
-					// cpu <= 10%  (1次/60秒，持续5s)
-					d.onTimePProf(DumpCPU, Dump100, 120, 5, func() func() { return d.dumpCpuProfile("normal_le100") })
-				} else if d.stat.Metrics.CpuUsage <= 300 {
-					// 10% < cpu <= 30%  (1次/35秒，持续5s)
-					d.onTimePProf(DumpCPU, Dump300, 50, 5, func() func() { return d.dumpCpuProfile("normal_le300") })
-				} else if d.stat.Metrics.CpuUsage <= 500 {
-					// 30% < cpu <= 50%  (1次/15秒，持续5s)
-					d.onTimePProf(DumpCPU, Dump500, 30, 5, func() func() { return d.dumpCpuProfile("normal_le500") })
-				} else if d.stat.Metrics.CpuUsage <= 700 {
-					// 50% < cpu <= 70%  (1次/10秒，持续5s)
-					d.onTimePProf(DumpCPU, Dump700, 20, 5, func() func() { return d.dumpCpuProfile("normal_le700") })
-				} else {
-					//  70% < cpu (1次/6秒，持续5s)
-					d.onTimePProf(DumpCPU, Dump800, 6, 5, func() func() { return d.dumpCpuProfile("normal_le1000") })
-				}
-			}
+	// 崩溃循环时尽早抓取快照
+	d.startCrashLoopPolicy()
+
+	// 每轮采集完成后检查触发规则
+	d.rulesOnce.Do(func() {
+		d.stat.OnCollect(d.onCollect)
+	})
+}
+
+// onCollect 在采集协程中执行，metrics为同一轮采集的结果
+func (d *dProf) onCollect(metrics *stat.Metrics) {
+	// 宿主机争抢cpu时不抓取cpu剖析
+	if metrics.HostCpu.Steal >= hostStealThreshold {
+		if !d.hostContention {
+			d.logger.Warn("host cpu contention, skip cpu profiles", "steal", metrics.HostCpu.Steal)
 		}
-	}()
+		d.hostContention = true
+	} else {
+		d.hostContention = false
+	}
+
+	d.learnBaselines(metrics, time.Now())
+	d.evaluateRules(metrics, d.hostContention)
+}
+
+// dump 按类型输出剖析文件
+func (d *dProf) dump(kind int, tag string) func() {
+	switch kind {
+	case DumpCPU:
+		return d.dumpCpuProfile(tag)
+	case DumpMEM:
+		return d.dumpHeapProfile(tag)
+	case DumpGoroutine:
+		return d.dumpGoroutineProfile(tag)
+	case DumpTrace:
+		return d.dumpTrace(tag)
+	case DumpFd:
+		return d.dumpFdInventory(tag)
+	}
+
+	return func() {}
 }
 
 // dumpCpuProfile 输出cpu剖析文件
 func (d *dProf) dumpCpuProfile(tag string) func() {
 	nop := func() {}
 	kind := "cpu"
+	start := time.Now()
 
 	f, err := d.createDumpFile(fmt.Sprintf("%s-%s", kind, tag))
 	if err != nil {
-		log.Println(err)
+		d.logger.Error("create dump file failed", "kind", kind, "tag", tag, "err", err)
+		d.metrics.captureDone(kind, tag, start, 0, err)
 		return nop
 	}
 
 	// 开始采样
 	err = pprof.StartCPUProfile(f)
 	if err != nil {
-		log.Println(err)
+		d.closeDumpFile(f, kind, tag, start, err)
 		return nop
 	}
 
 	return func() {
 		// 结束采样并写文件
 		pprof.StopCPUProfile()
-		_ = f.Sync()
-		_ = f.Close()
+		d.closeDumpFile(f, kind, tag, start, nil)
 	}
 }
 
@@ -212,25 +344,69 @@ func (d *dProf) dumpCpuProfile(tag string) func() {
 func (d *dProf) dumpHeapProfile(tag string) func() {
 	nop := func() {}
 	kind := "heap"
+	start := time.Now()
 
 	f, err := d.createDumpFile(fmt.Sprintf("%s-%s", kind, tag))
 	if err != nil {
-		log.Println(err)
+		d.logger.Error("create dump file failed", "kind", kind, "tag", tag, "err", err)
+		d.metrics.captureDone(kind, tag, start, 0, err)
 		return nop
 	}
 
 	bakMemProfileRate := runtime.MemProfileRate
 	// 尽量多
 	runtime.MemProfileRate = 4096
-	log.Println(bakMemProfileRate)
+	d.logger.Debug("set mem profile rate", "kind", kind, "tag", tag, "old", bakMemProfileRate, "new", runtime.MemProfileRate)
 
 	return func() {
 		err := pprof.Lookup(kind).WriteTo(f, 0)
-		if err != nil {
-			log.Println(err)
-		}
-		_ = f.Sync()
-		_ = f.Close()
+		d.closeDumpFile(f, kind, tag, start, err)
 		runtime.MemProfileRate = bakMemProfileRate
 	}
 }
+
+// dumpGoroutineProfile 输出协程快照
+func (d *dProf) dumpGoroutineProfile(tag string) func() {
+	nop := func() {}
+	kind := "goroutine"
+	start := time.Now()
+
+	f, err := d.createDumpFile(fmt.Sprintf("%s-%s", kind, tag))
+	if err != nil {
+		d.logger.Error("create dump file failed", "kind", kind, "tag", tag, "err", err)
+		d.metrics.captureDone(kind, tag, start, 0, err)
+		return nop
+	}
+
+	return func() {
+		err := pprof.Lookup(kind).WriteTo(f, 0)
+		d.closeDumpFile(f, kind, tag, start, err)
+	}
+}
+
+// dumpTrace 输出执行跟踪
+func (d *dProf) dumpTrace(tag string) func() {
+	nop := func() {}
+	kind := "trace"
+	start := time.Now()
+
+	f, err := d.createDumpFile(fmt.Sprintf("%s-%s", kind, tag))
+	if err != nil {
+		d.logger.Error("create dump file failed", "kind", kind, "tag", tag, "err", err)
+		d.metrics.captureDone(kind, tag, start, 0, err)
+		return nop
+	}
+
+	// 开始跟踪
+	err = trace.Start(f)
+	if err != nil {
+		d.closeDumpFile(f, kind, tag, start, err)
+		return nop
+	}
+
+	return func() {
+		// 结束跟踪并写文件
+		trace.Stop()
+		d.closeDumpFile(f, kind, tag, start, nil)
+	}
+}
@@ -1,23 +1,81 @@
 package internal
 
 import (
-	"fmt"
+	"github.com/dan-and-dna/dprof/stat"
 	"os"
 	"path"
+	"syscall"
 	"time"
 )
 
-// createDumpFile 尝试创建dump文件
-func (d *dProf) createDumpFile(kind string) (*os.File, error) {
-	// 二进制路径
-	appName := path.Base(os.Args[0])
+// dumpFileExt dump文件的后缀
+const dumpFileExt = "pprof"
 
-	pprofPath := fmt.Sprintf("./%s-%d-%s-%s.pprof", appName, os.Getpid(), kind, time.Now().Format("2006-01-02_15-04-05"))
-	f, err := os.Create(pprofPath)
+// getAppName 二进制名
+func getAppName() string {
+	return path.Base(os.Args[0])
+}
+
+// platformStatOptions 读取/proc和cgroup的采集器
+func platformStatOptions() []stat.Option {
+	opts := []stat.Option{
+		stat.WithSampler(NewProcStatSampler(), time.Second),
+		stat.WithSampler(NewProcSelfSampler(), time.Second),
+		stat.WithSampler(NewTcpSampler(), 5*time.Second),
+	}
+
+	// 不在cgroup中时不采集
+	if sampler, err := NewCgroupCpuSampler(); err == nil {
+		opts = append(opts, stat.WithSampler(sampler, time.Second))
+	}
+
+	// 内核没有开启PSI时不采集
+	if sampler, err := NewPSISampler(); err == nil {
+		opts = append(opts, stat.WithSampler(sampler, time.Second))
+	}
+
+	return opts
+}
+
+// getMaxFds RLIMIT_NOFILE的软限制
+func getMaxFds() uint64 {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0
+	}
+
+	return rlimit.Cur
+}
+
+// lockFile 给文件加排他锁，文件描述符都关闭后才释放
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// publishCrashOutput 把已经加锁并写入进程号的临时文件链接到正式的文件名，文件名已存在时返回的错误满足os.IsExist
+func publishCrashOutput(f *os.File, path string) (*os.File, error) {
+	err := os.Link(f.Name(), path)
 	if err != nil {
-		// 直接崩比较好，输出堆栈比较好
 		return nil, err
 	}
+	_ = os.Remove(f.Name())
 
 	return f, nil
 }
+
+// crashOutputInUse 崩溃输出文件是否还有进程在使用，使用中的文件被加了锁
+func crashOutputInUse(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return err == syscall.EWOULDBLOCK
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	return false
+}
@@ -1,24 +1,59 @@
 package internal
 
 import (
-	"fmt"
+	"github.com/dan-and-dna/dprof/stat"
 	"os"
 	"path"
 	"path/filepath"
-	"time"
 )
 
-// createDumpFile 尝试创建dump文件
-func (d *dProf) createDumpFile(kind string) (*os.File, error) {
-	// 二进制路径
-	appName := path.Base(filepath.ToSlash(os.Args[0]))
+// dumpFileExt dump文件的后缀
+const dumpFileExt = "prof"
 
-	pprofPath := fmt.Sprintf("./%s-%d-%s-%s.prof", appName, os.Getpid(), kind, time.Now().Format("2006-01-02_15-04-05"))
-	f, err := os.Create(pprofPath)
+// getAppName 二进制名
+func getAppName() string {
+	return path.Base(filepath.ToSlash(os.Args[0]))
+}
+
+// platformStatOptions windows下没有额外的采集器
+func platformStatOptions() []stat.Option {
+	return nil
+}
+
+// getMaxFds windows下没有fd的限制
+func getMaxFds() uint64 {
+	return 0
+}
+
+// lockFile windows下打开的文件不能被重命名和删除，不需要加锁
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// publishCrashOutput 把已经写入进程号的临时文件链接到正式的文件名，windows下打开的文件不能删除，关闭后再重新打开
+func publishCrashOutput(f *os.File, path string) (*os.File, error) {
+	err := os.Link(f.Name(), path)
 	if err != nil {
-		// 直接崩比较好，输出堆栈比较好
 		return nil, err
 	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// crashOutputInUse 崩溃输出文件是否还有进程在使用，按第一行记录的进程号判断
+func crashOutputInUse(path string) bool {
+	pid, _, err := readCrashOutputHeader(path)
+	if err != nil || pid <= 0 || pid == os.Getpid() {
+		return false
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
 
-	return f, nil
+	return true
 }
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// createDumpFile 尝试创建dump文件
+func (d *dProf) createDumpFile(kind string) (*os.File, error) {
+	return d.createDumpFileWithExt(kind, dumpFileExt)
+}
+
+// createDumpFileWithExt 尝试创建指定后缀的dump文件，用于非pprof格式的报告
+func (d *dProf) createDumpFileWithExt(kind, ext string) (*os.File, error) {
+	err := os.MkdirAll(d.options.DumpDir, 0755)
+	if err != nil {
+		d.metrics.dumpFileErrors.Inc()
+		return nil, err
+	}
+
+	// 同一秒内同类的文件加上序号，避免覆盖
+	prefix := fmt.Sprintf("%s-%d-%s-%s", getAppName(), os.Getpid(), kind, time.Now().Format("2006-01-02_15-04-05"))
+	name := fmt.Sprintf("%s.%s", prefix, ext)
+	f, err := os.OpenFile(filepath.Join(d.options.DumpDir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	for i := 1; os.IsExist(err) && i < 100; i++ {
+		name = fmt.Sprintf("%s-%d.%s", prefix, i, ext)
+		f, err = os.OpenFile(filepath.Join(d.options.DumpDir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		// 直接崩比较好，输出堆栈比较好
+		d.metrics.dumpFileErrors.Inc()
+		return nil, err
+	}
+
+	d.logger.Debug("dump file created", "kind", kind, "path", f.Name())
+
+	return f, nil
+}
+
+// closeDumpFile 写完并关闭dump文件，记录本次抓取的结果
+func (d *dProf) closeDumpFile(f *os.File, kind, tag string, start time.Time, err error) {
+	_ = f.Sync()
+	var size int64
+	if info, statErr := f.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	_ = f.Close()
+
+	if err != nil {
+		d.logger.Error("capture failed", "kind", kind, "tag", tag, "path", f.Name(), "err", err)
+	} else {
+		d.logger.Info("capture finished", "kind", kind, "tag", tag, "path", f.Name(), "size", size, "duration", time.Since(start))
+	}
+
+	d.metrics.captureDone(kind, tag, start, size, err)
+}
+
+// isDumpFile 是否是当前程序的dump文件，包括剖析文件和崩溃报告，不包括正在使用的崩溃输出文件和状态文件
+func isDumpFile(name string) bool {
+	if !strings.HasPrefix(name, getAppName()+"-") || isCrashOutput(name) {
+		return false
+	}
+
+	// 旧版本的状态文件
+	return name != getAppName()+"-dprof-state.json"
+}
@@ -0,0 +1,22 @@
+package internal
+
+import "testing"
+
+func TestIsDumpFile(t *testing.T) {
+	app := getAppName()
+	tests := map[string]bool{
+		app + "-123-cpu-normal_le100-2024-01-01_00-00-00.pprof": true,
+		app + "-123-crash-fatal-2024-01-01_00-00-00.txt":        true,
+		app + "-123-2024-01-01_00-00-00.crash.log":              false,
+		app + "-crash.log":                        false,
+		app + ".dprof-state.json":                 false,
+		app + "-dprof-state.json":                 false,
+		"other-123-cpu-2024-01-01_00-00-00.pprof": false,
+	}
+
+	for name, want := range tests {
+		if got := isDumpFile(name); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dan-and-dna/dprof/stat"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	direntReclen = int(unsafe.Offsetof(syscall.Dirent{}.Reclen))
+	direntName   = int(unsafe.Offsetof(syscall.Dirent{}.Name))
+)
+
+/*
+fdDir 读取/proc/self/fd，目录一直打开，每次从头用getdents读到复用的缓冲区，readlinkat也使用复用的缓冲区，读取时不分配内存；
+零值可以直接使用，不能并发使用
+*/
+type fdDir struct {
+	fd     int
+	opened bool
+	buf    []byte
+	link   [64]byte // socket:[12345] 之类的链接目标，更长的不是socket
+}
+
+// count 打开的fd数，不包括fd目录自己的fd
+func (d *fdDir) count(dir string) (uint64, error) {
+	var n uint64
+	err := d.rangeEntries(dir, func(name []byte) {
+		n++
+	})
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	return n - 1, nil
+}
+
+// socketInodes 当前进程打开的socket的inode，写入inodes
+func (d *fdDir) socketInodes(dir string, inodes map[uint64]struct{}) error {
+	return d.rangeEntries(dir, func(name []byte) {
+		n, err := readlinkat(d.fd, name, d.link[:])
+		if err != nil {
+			return
+		}
+
+		if inode, ok := socketLinkInode(d.link[:n]); ok {
+			inodes[inode] = struct{}{}
+		}
+	})
+}
+
+// rangeEntries 从头读取目录，跳过.和..，name以NUL结尾，只在fn中有效
+func (d *fdDir) rangeEntries(dir string, fn func(name []byte)) error {
+	if !d.opened {
+		fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return &os.PathError{Op: "open", Path: dir, Err: err}
+		}
+		d.fd, d.opened = fd, true
+	}
+	if d.buf == nil {
+		d.buf = make([]byte, 8192)
+	}
+
+	// /proc/self/fd回到开头后重新读会重新生成内容
+	_, err := syscall.Seek(d.fd, 0, io.SeekStart)
+	if err != nil {
+		d.close()
+		return os.NewSyscallError("seek", err)
+	}
+
+	for {
+		n, err := syscall.Getdents(d.fd, d.buf)
+		if err != nil {
+			d.close()
+			return os.NewSyscallError("getdents", err)
+		}
+		if n <= 0 {
+			return nil
+		}
+
+		for data := d.buf[:n]; len(data) > direntName; {
+			reclen := int(binary.NativeEndian.Uint16(data[direntReclen:]))
+			if reclen <= direntName || reclen > len(data) {
+				break
+			}
+
+			name := data[direntName:reclen]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i+1]
+			}
+			if !bytes.Equal(name, []byte(".\x00")) && !bytes.Equal(name, []byte("..\x00")) {
+				fn(name)
+			}
+			data = data[reclen:]
+		}
+	}
+}
+
+func (d *fdDir) close() {
+	_ = syscall.Close(d.fd)
+	d.opened = false
+}
+
+// readlinkat name以NUL结尾，syscall包没有导出readlinkat
+func readlinkat(dirfd int, name, buf []byte) (int, error) {
+	n, _, errno := syscall.Syscall6(syscall.SYS_READLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(&name[0])),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(n), nil
+}
+
+// socketLinkInode 不分配内存地解析fd的链接目标 socket:[12345]
+func socketLinkInode(target []byte) (uint64, bool) {
+	if !bytes.HasPrefix(target, []byte("socket:[")) || !bytes.HasSuffix(target, []byte("]")) {
+		return 0, false
+	}
+
+	return stat.ParseUint(target[len("socket:[") : len(target)-1])
+}
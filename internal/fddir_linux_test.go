@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFdDir(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		t.Fatal(err)
+	}
+
+	fdPath := filepath.Join(procSelfPath, "fd")
+	var d fdDir
+	inodes := make(map[uint64]struct{})
+	if err := d.socketInodes(fdPath, inodes); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := inodes[st.Ino]; !ok {
+		t.Errorf("listener inode %d not found in %v", st.Ino, inodes)
+	}
+
+	// Readdirnames的结果里多了fdDir一直打开的fd和自己打开的fd
+	n, err := d.count(fdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.Open(fdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := dir.Readdirnames(-1)
+	_ = dir.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != uint64(len(names)-2) {
+		t.Errorf("count: got %d, want %d", n, len(names)-2)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = d.count(fdPath)
+		clear(inodes)
+		_ = d.socketInodes(fdPath, inodes)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs per sample, want 0", allocs)
+	}
+}
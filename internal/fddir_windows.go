@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"os"
+	"path"
+)
+
+// fdDir windows下没有/proc/self/fd，打开目录会返回错误
+type fdDir struct{}
+
+// count 打开的fd数，不包括读取目录时自己打开的fd
+func (d *fdDir) count(dir string) (uint64, error) {
+	names, err := readDirNames(dir)
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+
+	return uint64(len(names) - 1), nil
+}
+
+// socketInodes 当前进程打开的socket的inode，写入inodes
+func (d *fdDir) socketInodes(dir string, inodes map[uint64]struct{}) error {
+	names, err := readDirNames(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		target, err := os.Readlink(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		if inode, ok := parseSocketInode(target); ok {
+			inodes[inode] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
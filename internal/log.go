@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+)
+
+// levelHandler 在原有handler的基础上再按最低日志级别过滤
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+func newLogger(logger *slog.Logger, level slog.Leveler) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if level == nil {
+		return logger
+	}
+
+	return slog.New(&levelHandler{level: level, handler: logger.Handler()})
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, handler: h.handler.WithGroup(name)}
+}
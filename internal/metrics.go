@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"time"
+)
+
+const (
+	CaptureResultSuccess = "success"
+	CaptureResultError   = "error"
+
+	SkipReasonCooldown = "cooldown"
+	SkipReasonInFlight = "in_flight"
+	SkipReasonHostBusy = "host_contention" // 宿主机争抢cpu，抓到的cpu剖析说明不了问题
+)
+
+// selfMetrics dprof自身的指标
+type selfMetrics struct {
+	captures        *prometheus.CounterVec
+	captureDuration *prometheus.HistogramVec
+	bytesWritten    *prometheus.CounterVec
+	skipped         *prometheus.CounterVec
+	dumpFileErrors  prometheus.Counter
+
+	previousRunCrashed prometheus.Gauge
+	restarts           prometheus.Gauge
+	crashLoop          prometheus.Gauge
+
+	anomalyScore *prometheus.GaugeVec
+}
+
+func newSelfMetrics(namespace string, constLabels prometheus.Labels, dumpDir string) (*selfMetrics, []prometheus.Collector) {
+	m := &selfMetrics{
+		captures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "captures_total",
+			Help:        "抓取剖析的次数",
+			ConstLabels: constLabels,
+		}, []string{"kind", "tag", "result"}),
+		captureDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "capture_duration_seconds",
+			Help:        "从开始抓取到写完文件的耗时",
+			ConstLabels: constLabels,
+			Buckets:     []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60},
+		}, []string{"kind"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "dump_written_bytes_total",
+			Help:        "写入dump文件的字节数",
+			ConstLabels: constLabels,
+		}, []string{"kind"}),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "captures_skipped_total",
+			Help:        "满足条件但没有抓取的次数，reason为cooldown(冷却中)或in_flight(同类剖析执行中)",
+			ConstLabels: constLabels,
+		}, []string{"kind", "reason"}),
+		dumpFileErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "dump_file_errors_total",
+			Help:        "创建dump文件失败的次数",
+			ConstLabels: constLabels,
+		}),
+		previousRunCrashed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "previous_run_crashed",
+			Help:        "上一次运行是否因为没有恢复的panic或致命错误崩溃 1为是",
+			ConstLabels: constLabels,
+		}),
+		restarts: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "restarts",
+			Help:        "dump目录中记录的累计重启次数",
+			ConstLabels: constLabels,
+		}),
+		crashLoop: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "crash_loop",
+			Help:        "是否处于崩溃循环(短时间内多次重启) 1为是",
+			ConstLabels: constLabels,
+		}),
+		anomalyScore: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "anomaly_zscore",
+			Help:        "异常检测的指标偏离基线几个标准差，基线还没学好时为0",
+			ConstLabels: constLabels,
+		}, []string{"name"}),
+	}
+
+	// dump目录当前的占用，抓取时才读取目录
+	dumpDirCollector := &dumpDirCollector{
+		dumpDir: dumpDir,
+		files:   prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "dump_files"), "dump目录中当前进程所属程序的文件数", nil, constLabels),
+		bytes:   prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "dump_files_bytes"), "dump目录中当前进程所属程序的文件总大小", nil, constLabels),
+	}
+
+	return m, []prometheus.Collector{
+		m.captures,
+		m.captureDuration,
+		m.bytesWritten,
+		m.skipped,
+		m.dumpFileErrors,
+		m.previousRunCrashed,
+		m.restarts,
+		m.crashLoop,
+		m.anomalyScore,
+		dumpDirCollector,
+	}
+}
+
+// captureDone 记录一次抓取的结果
+func (m *selfMetrics) captureDone(kind, tag string, start time.Time, size int64, err error) {
+	result := CaptureResultSuccess
+	if err != nil {
+		result = CaptureResultError
+	}
+
+	m.captures.WithLabelValues(kind, tag, result).Inc()
+	m.captureDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if size > 0 {
+		m.bytesWritten.WithLabelValues(kind).Add(float64(size))
+	}
+}
+
+// dumpDirCollector dump目录的文件数和总大小，每次抓取只读取一次目录
+type dumpDirCollector struct {
+	dumpDir string
+	files   *prometheus.Desc
+	bytes   *prometheus.Desc
+}
+
+func (c *dumpDirCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.files
+	ch <- c.bytes
+}
+
+func (c *dumpDirCollector) Collect(ch chan<- prometheus.Metric) {
+	files, size := dumpDirUsage(c.dumpDir)
+	ch <- prometheus.MustNewConstMetric(c.files, prometheus.GaugeValue, float64(files))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(size))
+}
+
+// dumpDirUsage 统计dump目录中以程序名开头的文件数和总大小
+func dumpDirUsage(dumpDir string) (int, int64) {
+	entries, err := os.ReadDir(dumpDir)
+	if err != nil {
+		return 0, 0
+	}
+
+	var files int
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || !isDumpFile(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files++
+		size += info.Size()
+	}
+
+	return files, size
+}
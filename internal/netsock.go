@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorBadSocketTable = errors.New("bad socket table of /proc/net")
+)
+
+// tcpStates /proc/net/tcp中st列的取值
+var tcpStates = map[uint64]string{
+	0x01: "ESTABLISHED",
+	0x02: "SYN_SENT",
+	0x03: "SYN_RECV",
+	0x04: "FIN_WAIT1",
+	0x05: "FIN_WAIT2",
+	0x06: "TIME_WAIT",
+	0x07: "CLOSE",
+	0x08: "CLOSE_WAIT",
+	0x09: "LAST_ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+}
+
+// Socket /proc/net下的一个socket
+type Socket struct {
+	Proto  string // tcp、tcp6、udp、udp6、unix
+	Local  string // ip:port，unix为路径
+	Remote string
+	State  string // tcp的状态，其他协议为空
+	Inode  uint64
+}
+
+/*
+ReadSocketTable 读取/proc/net/tcp、tcp6、udp、udp6格式的socket表
+
+	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+	0: 0100007F:BC8F 00000000:0000 0A 00000000:00000000 00:00000000 00000000 65534        0 913 1 ...
+*/
+func ReadSocketTable(filename, proto string) ([]Socket, error) {
+	lines, err := ReadLines(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []Socket
+	for i, line := range lines {
+		// 表头
+		if i == 0 {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			return nil, ErrorBadSocketTable
+		}
+
+		local, err := parseSocketAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remote, err := parseSocketAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		st, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		socket := Socket{Proto: proto, Local: local, Remote: remote, Inode: inode}
+		if strings.HasPrefix(proto, "tcp") {
+			socket.State = tcpStates[st]
+		}
+		sockets = append(sockets, socket)
+	}
+
+	return sockets, nil
+}
+
+// parseSocketAddr 地址是按32位整数以主机字节序输出的十六进制，端口是网络字节序
+func parseSocketAddr(s string) (string, error) {
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", ErrorBadSocketTable
+	}
+
+	b, err := hex.DecodeString(host)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return "", ErrorBadSocketTable
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(b[i:]))
+	}
+
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(p, 10)), nil
+}
+
+/*
+ReadUnixSockets 读取/proc/net/unix，没有绑定路径的socket路径为空
+
+	Num       RefCount Protocol Flags    Type St Inode Path
+	00000000e46e84ab: 00000003 00000000 00000000 0001 03 27100 /run/app.sock
+*/
+func ReadUnixSockets(filename string) ([]Socket, error) {
+	lines, err := ReadLines(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []Socket
+	for i, line := range lines {
+		if i == 0 {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 7 {
+			return nil, ErrorBadSocketTable
+		}
+
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		socket := Socket{Proto: "unix", Inode: inode}
+		if len(fields) > 7 {
+			socket.Local = fields[7]
+		}
+		sockets = append(sockets, socket)
+	}
+
+	return sockets, nil
+}
+
+// readSockets 读取进程所在网络命名空间的所有socket，按inode索引，读取失败的表跳过
+func readSockets(procPath string) map[uint64]Socket {
+	sockets := make(map[uint64]Socket)
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		table, err := ReadSocketTable(path.Join(procPath, "net", proto), proto)
+		if err != nil {
+			continue
+		}
+		for _, socket := range table {
+			sockets[socket.Inode] = socket
+		}
+	}
+
+	if table, err := ReadUnixSockets(path.Join(procPath, "net", "unix")); err == nil {
+		for _, socket := range table {
+			sockets[socket.Inode] = socket
+		}
+	}
+
+	return sockets
+}
+
+// parseSocketInode 解析fd的readlink结果 socket:[12345]
+func parseSocketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+
+	inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return inode, true
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSocketTable(t *testing.T) {
+	path := writeProcFile(t, `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:BC8F 00000000:0000 0A 00000000:00000000 00:00000000 00000000 65534        0 913 1 0000000072bb6fbb 100 0 0 10 0
+   1: 0100007F:BFC2 0100007F:BC8F 08 00000000:00000000 02:000010A6 00000000     0        0 1666 2 0000000020f3ffbe 20 4 0 16 8
+`)
+
+	sockets, err := ReadSocketTable(path, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Socket{
+		{Proto: "tcp", Local: "127.0.0.1:48271", Remote: "0.0.0.0:0", State: "LISTEN", Inode: 913},
+		{Proto: "tcp", Local: "127.0.0.1:49090", Remote: "127.0.0.1:48271", State: "CLOSE_WAIT", Inode: 1666},
+	}
+	if len(sockets) != len(want) {
+		t.Fatalf("got %+v", sockets)
+	}
+	for i := range want {
+		if sockets[i] != want[i] {
+			t.Errorf("got %+v, want %+v", sockets[i], want[i])
+		}
+	}
+}
+
+func TestParseSocketAddr_IPv6(t *testing.T) {
+	addr, err := parseSocketAddr("00000000000000000000000001000000:1F90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "[::1]:8080" {
+		t.Errorf("got %s", addr)
+	}
+}
+
+func TestReadFdInventory(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"net/tcp":  "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n   0: 0100007F:1F90 0100007F:BFC2 01 00000000:00000000 00:00000000 00000000 0 0 913 1\n",
+		"net/unix": "Num       RefCount Protocol Flags    Type St Inode Path\n00000000e46e84ab: 00000003 00000000 00000000 0001 03 27100 /run/app.sock\n",
+	})
+	links := map[string]string{
+		"0":  "/dev/null",
+		"3":  "socket:[913]",
+		"4":  "socket:[27100]",
+		"5":  "pipe:[123]",
+		"10": "/var/log/app.log",
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, "fd", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadFdInventory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FdEntry{
+		{Fd: 0, Type: "device", Target: "/dev/null", Group: "device"},
+		{Fd: 3, Type: "socket", Target: "tcp 127.0.0.1:8080 -> 127.0.0.1:49090 ESTABLISHED", Group: "socket tcp ESTABLISHED"},
+		{Fd: 4, Type: "socket", Target: "unix /run/app.sock", Group: "socket unix"},
+		{Fd: 5, Type: "pipe", Target: "pipe:[123]", Group: "pipe"},
+		{Fd: 10, Type: "file", Target: "/var/log/app.log", Group: "file"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %+v", entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("got %+v, want %+v", entries[i], want[i])
+		}
+	}
+}
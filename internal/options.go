@@ -0,0 +1,20 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"log/slog"
+)
+
+// Options dprof的配置
+type Options struct {
+	DumpDir     string       // dump文件的目录，默认为当前目录
+	Logger      *slog.Logger // 日志，默认为slog.Default()
+	LogLevel    slog.Leveler // 最低日志级别，为nil时由Logger决定
+	Repanic     bool         // 输出崩溃报告后是否再次panic
+	CrashOutput bool         // 是否把致命错误的输出重定向到dump目录，默认开启
+	Rules       []Rule       // 触发规则，为nil时使用DefaultRules
+	Anomalies   []Anomaly    // 基线异常检测，追加在触发规则之后
+	StatOptions []stat.Option
+}
+
+type Option func(opts *Options)
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"path"
+	"time"
+)
+
+const (
+	procSelfPath = "/proc/self"
+
+	fdLeakWindow         = time.Minute // 观察窗口
+	fdLeakMinSlope       = 0.1         // 窗口内最小增长斜率，单位 个/秒，即每分钟6个，按采集间隔换算成 个/采样
+	fdLeakMonotonicRatio = 0.9         // 窗口内不下降的采样占比
+)
+
+// ProcSelfSampler 采集当前进程的fd、线程、上下文切换和io，fd耗尽和线程暴涨(比如阻塞的cgo调用)从cpu和内存上看不出来
+type ProcSelfSampler struct {
+	fdPath     string
+	statusPath string
+	ioPath     string
+	reader     procReader
+	fds        fdDir
+	interval   time.Duration
+	fdTrend    *stat.TrendDetector
+}
+
+func NewProcSelfSampler() *ProcSelfSampler {
+	return newProcSelfSampler(procSelfPath)
+}
+
+func newProcSelfSampler(dir string) *ProcSelfSampler {
+	s := &ProcSelfSampler{
+		fdPath:     path.Join(dir, "fd"),
+		statusPath: path.Join(dir, "status"),
+		ioPath:     path.Join(dir, "io"),
+	}
+	s.SetInterval(time.Second)
+
+	return s
+}
+
+// SetInterval 按实际的采集间隔换算fd泄漏检测的窗口和斜率阈值
+func (s *ProcSelfSampler) SetInterval(interval time.Duration) {
+	s.interval = interval
+	s.fdTrend = newLeakTrend(fdLeakWindow, fdLeakMinSlope, fdLeakMonotonicRatio, interval)
+}
+
+func (s *ProcSelfSampler) Name() string {
+	return "procself"
+}
+
+func (s *ProcSelfSampler) Sample(m *stat.Metrics) error {
+	var process stat.ProcessStat
+
+	openFds, err := s.fds.count(s.fdPath)
+	if err != nil {
+		return err
+	}
+	process.OpenFds = openFds
+	process.MaxFds = getMaxFds()
+
+	// fd泄漏检测
+	process.FdLeakSuspected = s.fdTrend.Add(int(openFds))
+	process.FdSlope = s.fdTrend.Slope / s.interval.Seconds()
+
+	// Threads:	12
+	// voluntary_ctxt_switches:	150
+	// nonvoluntary_ctxt_switches:	545
+	status, err := s.reader.read(s.statusPath)
+	if err != nil {
+		return err
+	}
+	process.Threads, _ = lookupUint(status, "Threads")
+	process.VoluntaryCtxSwitches, _ = lookupUint(status, "voluntary_ctxt_switches")
+	process.InvoluntaryCtxSwitches, _ = lookupUint(status, "nonvoluntary_ctxt_switches")
+
+	// 没有开启task io accounting时没有这个文件
+	if io, err := s.reader.read(s.ioPath); err == nil {
+		process.ReadBytes, _ = lookupUint(io, "read_bytes")
+		process.WriteBytes, _ = lookupUint(io, "write_bytes")
+		process.ReadChars, _ = lookupUint(io, "rchar")
+		process.WriteChars, _ = lookupUint(io, "wchar")
+	}
+
+	process.Available = true
+	m.Process = process
+
+	return nil
+}
+
+// newLeakTrend 泄漏检测的趋势，观察时长和 个/秒 的斜率阈值按采集间隔换算成采样数和 个/采样
+func newLeakTrend(window time.Duration, minSlope, monotonicRatio float64, interval time.Duration) *stat.TrendDetector {
+	samples := int(window / interval)
+	if samples < 2 {
+		samples = 2
+	}
+
+	return stat.NewTrendDetector(samples, minSlope*interval.Seconds(), monotonicRatio)
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcSelfSampler(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"status": "Name:\tdprof\nThreads:\t12\nVmRSS:\t  1024 kB\nvoluntary_ctxt_switches:\t150\nnonvoluntary_ctxt_switches:\t545\n",
+		"io":     "rchar: 3980\nwchar: 100\nsyscr: 9\nsyscw: 1\nread_bytes: 4096\nwrite_bytes: 8192\n",
+	})
+	// fd目录下还有fd目录自己打开的那个fd
+	for _, name := range []string{"0", "1", "2", "3"} {
+		if err := os.MkdirAll(filepath.Join(dir, "fd", name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var m stat.Metrics
+	sampler := newProcSelfSampler(dir)
+	if err := sampler.Sample(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	process := m.Process
+	process.MaxFds = 0
+	want := stat.ProcessStat{
+		Available:              true,
+		OpenFds:                3,
+		Threads:                12,
+		VoluntaryCtxSwitches:   150,
+		InvoluntaryCtxSwitches: 545,
+		ReadBytes:              4096,
+		WriteBytes:             8192,
+		ReadChars:              3980,
+		WriteChars:             100,
+	}
+	if process != want {
+		t.Errorf("got %+v, want %+v", process, want)
+	}
+}
+
+func TestProcSelfSampler_SetInterval(t *testing.T) {
+	sampler := newProcSelfSampler(t.TempDir())
+	sampler.SetInterval(5 * time.Second)
+
+	// 每5秒多1个fd，即每秒0.2个，12次采样正好1分钟
+	for i := 0; i < 12; i++ {
+		suspected := sampler.fdTrend.Add(100 + i)
+		if suspected != (i == 11) {
+			t.Fatalf("sample %d: got suspected %v", i, suspected)
+		}
+	}
+	if slope := sampler.fdTrend.Slope / sampler.interval.Seconds(); math.Abs(slope-0.2) > 1e-9 {
+		t.Errorf("got slope %v, want 0.2", slope)
+	}
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	procStatPath = "/proc/stat"
+	// USER_HZ，/proc/stat中的时间单位。内核导出给用户态的时间单位与编译时的CONFIG_HZ无关，
+	// 除了alpha以外的架构都固定为100，go支持的架构都是100；读取sysconf(_SC_CLK_TCK)需要cgo
+	clockTicks = 100
+)
+
+// CpuTimes /proc/stat中一行cpu的累计时间，单位为clock tick
+type CpuTimes struct {
+	User    uint64
+	Nice    uint64
+	System  uint64
+	Idle    uint64
+	Iowait  uint64
+	Irq     uint64
+	Softirq uint64
+	Steal   uint64
+}
+
+// Total 所有状态的时间之和；guest和guest_nice已经包含在user和nice中，不再重复计算
+func (t CpuTimes) Total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.Iowait + t.Irq + t.Softirq + t.Steal
+}
+
+/*
+ReadProcStat 读取/proc/stat中所有cpu开头的行，key为cpu、cpu0、cpu1...
+
+	cpu  771 1 3697 11320785 1117 0 751 0 0 0
+	cpu0 443 0 1047 3761325 58 0 358 0 0 0
+	cpu1 230 0 1157 3780358 1018 0 15 0 0 0
+*/
+func ReadProcStat(path string) (map[string]CpuTimes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := parseProcStat(data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cpus := make(map[string]CpuTimes, len(samples))
+	for i, sample := range samples {
+		if sample.ok {
+			cpus[cpuName(i)] = sample.times
+		}
+	}
+
+	return cpus, nil
+}
+
+// cpuSample /proc/stat中一行cpu的时间，ok为false时这一次没有读到
+type cpuSample struct {
+	times CpuTimes
+	ok    bool
+}
+
+// parseProcStat 解析cpu开头的行，下标0为所有cpu的汇总，cpuN的下标为N+1；复用samples，不分配内存
+func parseProcStat(data []byte, samples []cpuSample) ([]cpuSample, error) {
+	for i := range samples {
+		samples[i].ok = false
+	}
+
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+
+		name, rest := stat.NextField(line)
+		if len(name) < 3 || string(name[:3]) != "cpu" {
+			continue
+		}
+
+		index := 0
+		if len(name) > 3 {
+			n, ok := stat.ParseUint(name[3:])
+			if !ok {
+				return samples, ErrorBadCPUStat
+			}
+			index = int(n) + 1
+		}
+
+		var values [8]uint64
+		count := 0
+		for i := range values {
+			var field []byte
+			field, rest = stat.NextField(rest)
+			// 老内核没有steal
+			if len(field) == 0 {
+				break
+			}
+
+			v, ok := stat.ParseUint(field)
+			if !ok {
+				return samples, ErrorBadCPUStat
+			}
+			values[i] = v
+			count++
+		}
+		if count < 7 {
+			return samples, ErrorBadCPUStat
+		}
+
+		for index >= len(samples) {
+			samples = append(samples, cpuSample{})
+		}
+		samples[index] = cpuSample{
+			times: CpuTimes{
+				User:    values[0],
+				Nice:    values[1],
+				System:  values[2],
+				Idle:    values[3],
+				Iowait:  values[4],
+				Irq:     values[5],
+				Softirq: values[6],
+				Steal:   values[7],
+			},
+			ok: true,
+		}
+	}
+
+	if len(samples) == 0 || !samples[0].ok {
+		return samples, ErrorBadCPUStat
+	}
+
+	return samples, nil
+}
+
+// cpuName parseProcStat的下标对应的cpu名字，0为cpu，N+1为cpuN
+func cpuName(index int) string {
+	if index == 0 {
+		return "cpu"
+	}
+
+	return "cpu" + strconv.Itoa(index-1)
+}
+
+// ProcStatSampler 按核心统计主机的cpu时间分布，区分是自己的代码热还是被同一台机器上的其他负载抢占
+type ProcStatSampler struct {
+	path   string
+	reader procReader
+	cur    []cpuSample
+	prev   []cpuSample
+	names  []string // 下标同cpuSample，避免每次生成cpu的名字
+}
+
+func NewProcStatSampler() *ProcStatSampler {
+	return &ProcStatSampler{path: procStatPath}
+}
+
+func (s *ProcStatSampler) Name() string {
+	return "procstat"
+}
+
+// Sample 计算两次采样之间各状态的时间占比
+func (s *ProcStatSampler) Sample(m *stat.Metrics) error {
+	data, err := s.reader.read(s.path)
+	if err != nil {
+		return err
+	}
+
+	cur, err := parseProcStat(data, s.cur)
+	s.cur = cur
+	if err != nil {
+		return err
+	}
+
+	// 两个缓冲区交替使用
+	prev := s.prev
+	s.prev, s.cur = cur, prev
+	if prev == nil {
+		return nil
+	}
+
+	cores := make([]stat.CpuModes, 0, len(cur)-1)
+	for i, sample := range cur {
+		// 两次采样之间上线的cpu(热插拔或cpuset变化)没有上一次的值，和0相减会得到很大的尖刺，下一次再计算
+		if !sample.ok || i >= len(prev) || !prev[i].ok {
+			continue
+		}
+
+		modes := cpuModes(prev[i].times, sample.times)
+		modes.Cpu = s.cpuName(i)
+		if i == 0 {
+			m.HostCpu = modes
+			continue
+		}
+		cores = append(cores, modes)
+	}
+	m.HostCpuCores = cores
+
+	return nil
+}
+
+// cpuName 缓存的cpu名字
+func (s *ProcStatSampler) cpuName(index int) string {
+	for len(s.names) <= index {
+		s.names = append(s.names, cpuName(len(s.names)))
+	}
+
+	return s.names[index]
+}
+
+// cpuModes 两次采样之间各状态的时间占比
+func cpuModes(prev, cur CpuTimes) stat.CpuModes {
+	if cur.Total() <= prev.Total() {
+		return stat.CpuModes{}
+	}
+	total := float64(cur.Total() - prev.Total())
+
+	ratio := func(prev, cur uint64) float64 {
+		if cur <= prev {
+			return 0
+		}
+		return float64(cur-prev) / total
+	}
+
+	return stat.CpuModes{
+		User:    ratio(prev.User+prev.Nice, cur.User+cur.Nice),
+		System:  ratio(prev.System, cur.System),
+		Idle:    ratio(prev.Idle, cur.Idle),
+		Iowait:  ratio(prev.Iowait, cur.Iowait),
+		Irq:     ratio(prev.Irq, cur.Irq),
+		Softirq: ratio(prev.Softirq, cur.Softirq),
+		Steal:   ratio(prev.Steal, cur.Steal),
+	}
+}
+
+// clockTicksToNs clock tick换算成纳秒
+func clockTicksToNs(ticks uint64) uint64 {
+	return ticks * uint64(time.Second) / clockTicks
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeProcFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stat")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadProcStat(t *testing.T) {
+	path := writeProcFile(t, `cpu  771 1 3697 11320785 1117 0 751 20 100 0
+cpu0 443 0 1047 3761325 58 0 358 10 50 0
+cpu1 328 1 2650 7559460 1059 0 393 10 50 0
+intr 12345
+ctxt 67890
+`)
+
+	cpus, err := ReadProcStat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cpus) != 3 {
+		t.Fatalf("got %d cpus, want 3", len(cpus))
+	}
+
+	// guest不计入总数
+	if total := cpus["cpu"].Total(); total != 771+1+3697+11320785+1117+0+751+20 {
+		t.Errorf("got total %d", total)
+	}
+	if cpus["cpu1"].Steal != 10 {
+		t.Errorf("got steal %d, want 10", cpus["cpu1"].Steal)
+	}
+}
+
+func TestProcStatSampler_Steal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stat")
+	sampler := &ProcStatSampler{path: path}
+
+	var m stat.Metrics
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := sampler.Sample(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("cpu  100 0 100 100 0 0 0 0\ncpu0 100 0 100 100 0 0 0 0\n")
+	write("cpu  150 0 150 150 0 0 0 50\ncpu0 150 0 150 150 0 0 0 50\n")
+
+	if math.Abs(m.HostCpu.Steal-0.25) > 1e-9 {
+		t.Errorf("got steal %f, want 0.25", m.HostCpu.Steal)
+	}
+	if len(m.HostCpuCores) != 1 || m.HostCpuCores[0].Cpu != "cpu0" {
+		t.Errorf("got cores %+v", m.HostCpuCores)
+	}
+
+	// cpu1上线，第一次采样没有上一次的值，不计算
+	write("cpu  200 0 200 200 0 0 0 50\ncpu0 150 0 150 150 0 0 0 50\ncpu1 50 0 50 50 0 0 0 0\n")
+	if len(m.HostCpuCores) != 1 || m.HostCpuCores[0].Cpu != "cpu0" {
+		t.Errorf("got cores %+v", m.HostCpuCores)
+	}
+	write("cpu  250 0 250 250 0 0 0 50\ncpu0 200 0 200 200 0 0 0 50\ncpu1 100 0 100 100 0 0 0 0\n")
+	if len(m.HostCpuCores) != 2 || m.HostCpuCores[1].User > 0.5 {
+		t.Errorf("got cores %+v", m.HostCpuCores)
+	}
+}
+
+func TestParseProcStat_NoAllocs(t *testing.T) {
+	data := []byte("cpu  771 1 3697 11320785 1117 0 751 20 100 0\ncpu0 443 0 1047 3761325 58 0 358 10 50 0\nintr 12345\n")
+
+	samples, err := parseProcStat(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		samples, _ = parseProcStat(data, samples)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs", allocs)
+	}
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dan-and-dna/dprof/stat"
+	"os"
+	"path"
+	"strconv"
+)
+
+const (
+	procPressureDir = "/proc/pressure"
+)
+
+var (
+	ErrorBadPressure = errors.New("bad pressure stall information")
+)
+
+/*
+ReadPressure 读取PSI文件，cpu在老内核上没有full这一行
+
+	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+*/
+func ReadPressure(filename string) (stat.PressureStat, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return stat.PressureStat{}, err
+	}
+
+	return parsePressure(data)
+}
+
+// parsePressure 解析PSI文件的内容，不分配内存
+func parsePressure(data []byte) (stat.PressureStat, error) {
+	var pressure stat.PressureStat
+
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+
+		name, rest := stat.NextField(line)
+		if len(name) == 0 {
+			continue
+		}
+
+		var target *stat.PressureLine
+		switch string(name) {
+		case "some":
+			target = &pressure.Some
+		case "full":
+			target = &pressure.Full
+		default:
+			return pressure, ErrorBadPressure
+		}
+
+		count := 0
+		for {
+			var field []byte
+			field, rest = stat.NextField(rest)
+			if len(field) == 0 {
+				break
+			}
+			count++
+
+			i := bytes.IndexByte(field, '=')
+			if i < 0 {
+				return pressure, ErrorBadPressure
+			}
+			key, value := field[:i], field[i+1:]
+
+			var err error
+			switch string(key) {
+			case "avg10":
+				target.Avg10, err = strconv.ParseFloat(string(value), 64)
+			case "avg60":
+				target.Avg60, err = strconv.ParseFloat(string(value), 64)
+			case "avg300":
+				target.Avg300, err = strconv.ParseFloat(string(value), 64)
+			case "total":
+				var ok bool
+				if target.Total, ok = stat.ParseUint(value); !ok {
+					err = ErrorBadPressure
+				}
+			}
+			if err != nil {
+				return pressure, err
+			}
+		}
+		if count != 4 {
+			return pressure, ErrorBadPressure
+		}
+	}
+
+	return pressure, nil
+}
+
+// pressureFiles 一个目录下cpu、memory、io的PSI文件，文件名为资源名+suffix
+type pressureFiles struct {
+	cpu    string
+	memory string
+	io     string
+}
+
+func newPressureFiles(dir, suffix string) pressureFiles {
+	return pressureFiles{
+		cpu:    path.Join(dir, "cpu"+suffix),
+		memory: path.Join(dir, "memory"+suffix),
+		io:     path.Join(dir, "io"+suffix),
+	}
+}
+
+// PSISampler 采集主机和cgroup的PSI，比gopsutil的使用率更能反映真正的资源不足
+type PSISampler struct {
+	reader      procReader
+	hostFiles   pressureFiles
+	cgroupFiles *pressureFiles // v2的文件，没有时为nil
+}
+
+// NewPSISampler 内核没有开启PSI时返回错误
+func NewPSISampler() (*PSISampler, error) {
+	s := &PSISampler{hostFiles: newPressureFiles(procPressureDir, "")}
+
+	cgroup := NewCgroup()
+	if err := cgroup.Init(); err == nil && cgroup.unified != "" {
+		if _, err := os.Stat(path.Join(cgroup.unified, "memory.pressure")); err == nil {
+			files := newPressureFiles(cgroup.unified, ".pressure")
+			s.cgroupFiles = &files
+		}
+	}
+
+	if _, err := os.Stat(procPressureDir); err != nil && s.cgroupFiles == nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *PSISampler) Name() string {
+	return "psi"
+}
+
+func (s *PSISampler) Sample(m *stat.Metrics) error {
+	var errs []error
+
+	hostPressure, err := s.readPressure(s.hostFiles)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	m.HostPressure = hostPressure
+
+	if s.cgroupFiles != nil {
+		cgroupPressure, err := s.readPressure(*s.cgroupFiles)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		m.CgroupPressure = cgroupPressure
+	}
+
+	return errors.Join(errs...)
+}
+
+// readPressure 读取cpu、memory、io的PSI
+func (s *PSISampler) readPressure(files pressureFiles) (stat.Pressure, error) {
+	var pressure stat.Pressure
+	var err error
+
+	if pressure.Cpu, err = s.readPressureFile(files.cpu); err != nil {
+		return pressure, err
+	}
+	if pressure.Memory, err = s.readPressureFile(files.memory); err != nil {
+		return pressure, err
+	}
+	if pressure.Io, err = s.readPressureFile(files.io); err != nil {
+		return pressure, err
+	}
+	pressure.Available = true
+
+	return pressure, nil
+}
+
+func (s *PSISampler) readPressureFile(filename string) (stat.PressureStat, error) {
+	data, err := s.reader.read(filename)
+	if err != nil {
+		return stat.PressureStat{}, err
+	}
+
+	return parsePressure(data)
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"testing"
+)
+
+func TestReadPressure(t *testing.T) {
+	path := writeProcFile(t, `some avg10=4.07 avg60=2.31 avg300=1.87 total=29460394
+full avg10=6.50 avg60=0.00 avg300=0.00 total=120
+`)
+
+	pressure, err := ReadPressure(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := stat.PressureStat{
+		Some: stat.PressureLine{Avg10: 4.07, Avg60: 2.31, Avg300: 1.87, Total: 29460394},
+		Full: stat.PressureLine{Avg10: 6.5, Total: 120},
+	}
+	if pressure != want {
+		t.Errorf("got %+v, want %+v", pressure, want)
+	}
+
+	if _, err := ReadPressure(writeProcFile(t, "some avg10=1\n")); err == nil {
+		t.Error("expected error for bad pressure")
+	}
+}
+
+func TestDefaultRules_MemoryPressure(t *testing.T) {
+	var rule *Rule
+	rules := DefaultRules()
+	for i := range rules {
+		if rules[i].Name == "memory_pressure" {
+			rule = &rules[i]
+		}
+	}
+	if rule == nil {
+		t.Fatal("memory_pressure rule not found")
+	}
+
+	m := &stat.Metrics{}
+	m.HostPressure.Available = true
+	m.HostPressure.Memory.Full.Avg10 = 10
+	if !rule.Condition(m) {
+		t.Error("host memory pressure should trigger")
+	}
+
+	// cgroup的PSI优先
+	m.CgroupPressure.Available = true
+	if rule.Condition(m) {
+		t.Error("cgroup memory pressure is 0, should not trigger")
+	}
+}
+
+func TestParsePressure_NoAllocs(t *testing.T) {
+	data := []byte("some avg10=4.07 avg60=2.31 avg300=1.87 total=29460394\nfull avg10=6.50 avg60=0.00 avg300=0.00 total=120\n")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = parsePressure(data)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs", allocs)
+	}
+}
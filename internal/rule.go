@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"math/bits"
+	"time"
+)
+
+const (
+	schedLatencyP99Threshold = 0.01 // 最近10秒调度延迟p99超过10ms
+	gcCpuFractionThreshold   = 0.25 // 最近10秒gc占用cpu超过25%
+	hostStealThreshold       = 0.2  // 主机cpu被宿主机上其他虚拟机抢占超过20%
+	cpuThrottledThreshold    = 0.25 // 最近1秒超过25%的时间周期被cgroup限流
+	memoryFullAvg10Threshold = 5    // 最近10秒超过5%的时间所有任务都在等待内存
+	fdUsageThreshold         = 0.8  // 打开的fd数超过RLIMIT_NOFILE的80%
+	threadsThreshold         = 1000 // 系统线程数超过1000，一般是阻塞的cgo调用或系统调用
+
+	fdUsageExitThreshold = 0.7 // fd占用降到70%以下才算恢复
+	threadsExitThreshold = 800 // 系统线程数降到800以下才算恢复
+	maxRuleSamples       = 64  // Samples最多64次
+)
+
+// Rule 触发规则，每秒检查一次，条件满足且过了冷却时间就抓取剖析
+type Rule struct {
+	Name      string                     // 规则名，也是dump文件的tag
+	Kinds     []int                      // 抓取的剖析类型 DumpCPU、DumpMEM、DumpGoroutine、DumpTrace、DumpFd
+	Key       int                        // 优先级，抓取后会重置同类剖析中优先级更低的规则的冷却时间
+	Interval  int64                      // 冷却时间，单位秒
+	KeepTime  int64                      // 持续时间，单位秒，只对cpu剖析和执行跟踪有效
+	Condition func(m *stat.Metrics) bool // 触发条件
+
+	For     time.Duration              // 条件需要持续满足多长时间，为0时不要求
+	Samples int                        // 最近Samples次检查(即最近Samples秒)中至少Count次满足条件，为0时不要求，最多64
+	Count   int                        // 为0时等于Samples
+	Exit    func(m *stat.Metrics) bool // 退出条件，设置后每次事件只抓取一次，直到退出条件满足，可以用比触发条件更宽的阈值避免来回抖动
+}
+
+// ruleState 规则的持续状态，只在采集协程中访问
+type ruleState struct {
+	since   time.Time // 条件开始连续满足的时间
+	history uint64    // 最近64次检查的结果，最低位是最近一次
+	active  bool      // 处于事件中，已经抓取过了
+}
+
+// check 记录本次检查的结果，返回是否应该抓取
+func (state *ruleState) check(rule *Rule, m *stat.Metrics, now time.Time) bool {
+	met := rule.Condition(m)
+
+	// 事件中不再抓取，直到退出条件满足
+	if state.active {
+		if rule.Exit(m) {
+			*state = ruleState{}
+		}
+		return false
+	}
+
+	state.history <<= 1
+	if met {
+		state.history |= 1
+		if state.since.IsZero() {
+			state.since = now
+		}
+	} else {
+		state.since = time.Time{}
+	}
+
+	if rule.Samples > 0 {
+		mask := uint64(1)<<rule.Samples - 1
+		if bits.OnesCount64(state.history&mask) < rule.Count {
+			return false
+		}
+	} else if !met {
+		return false
+	}
+
+	return rule.For <= 0 || (met && now.Sub(state.since) >= rule.For)
+}
+
+// hasKind 规则是否会抓取某类剖析
+func (rule *Rule) hasKind(kind int) bool {
+	for _, k := range rule.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultRules 默认的触发规则
+func DefaultRules() []Rule {
+	return []Rule{
+		// 进程的内存相关剖析 (1次/120秒)
+		{Name: "normal_gte100", Kinds: []int{DumpMEM}, Key: Dump100, Interval: 120, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.MemUsage >= 100
+		}},
+		// 协程泄漏剖析 (1次/300秒)
+		{Name: "leak", Kinds: []int{DumpGoroutine}, Key: Dump100, Interval: 300, Condition: func(m *stat.Metrics) bool {
+			return m.GoroutineLeakSuspected
+		}},
+		// 内存不足，所有任务都在等待内存 (1次/120秒)
+		{Name: "memory_pressure", Kinds: []int{DumpMEM}, Key: DumpMemPressure, Interval: 120, Condition: func(m *stat.Metrics) bool {
+			return m.Pressure().Memory.Full.Avg10 > memoryFullAvg10Threshold
+		}},
+		// fd快用完了，输出fd清单，看看是哪些协程持有连接或文件 (1次/300秒)
+		{Name: "fd_exhaustion", Kinds: []int{DumpFd}, Key: DumpFdExhaustion, Interval: 300, Condition: func(m *stat.Metrics) bool {
+			return m.Process.FdUsage() >= fdUsageThreshold
+		}, Exit: func(m *stat.Metrics) bool {
+			return m.Process.FdUsage() < fdUsageExitThreshold
+		}},
+		// fd持续增长 (1次/300秒)
+		{Name: "fd_leak", Kinds: []int{DumpFd}, Key: DumpFdLeak, Interval: 300, Condition: func(m *stat.Metrics) bool {
+			return m.Process.FdLeakSuspected
+		}},
+		// CLOSE_WAIT持续增长，对端关闭了连接但是我们没有关闭，看看是哪些协程持有连接 (1次/300秒)
+		{Name: "close_wait_leak", Kinds: []int{DumpGoroutine}, Key: DumpCloseWait, Interval: 300, Condition: func(m *stat.Metrics) bool {
+			return m.Tcp.CloseWaitLeakSuspected
+		}},
+		// 系统线程暴涨，看看是哪些协程阻塞在cgo调用或系统调用上 (1次/300秒)
+		{Name: "thread_explosion", Kinds: []int{DumpGoroutine}, Key: DumpThreads, Interval: 300, Condition: func(m *stat.Metrics) bool {
+			return m.Process.Threads >= threadsThreshold
+		}, Exit: func(m *stat.Metrics) bool {
+			return m.Process.Threads < threadsExitThreshold
+		}},
+		// 延迟相关剖析，cpu剖析 + 执行跟踪 (1次/60秒，持续5s)
+		{Name: "sched_latency_p99", Kinds: []int{DumpCPU, DumpTrace}, Key: DumpSchedLatency, Interval: 60, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.SchedLatencyP99 >= schedLatencyP99Threshold
+		}},
+		{Name: "gc_cpu_fraction", Kinds: []int{DumpCPU, DumpTrace}, Key: DumpGCCpu, Interval: 60, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.SchedLatencyP99 < schedLatencyP99Threshold && m.GCCpuFraction >= gcCpuFractionThreshold
+		}},
+		// cgroup限流剖析，看看用满配额的是哪些代码 (1次/60秒，持续5s)
+		{Name: "cpu_throttled", Kinds: []int{DumpCPU}, Key: DumpCpuThrottled, Interval: 60, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.CgroupCpu.ThrottledRatio >= cpuThrottledThreshold
+		}},
+		// 当前cpu超过100，且抖动厉害，需要单独记录
+		{Name: "odd_gte100", Kinds: []int{DumpCPU}, Key: Dump900, Interval: 20, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.Window(stat.WindowCpuUsage).Stddev >= 100 && m.CpuUsage >= 100
+		}},
+		// 定位负载，cpu在同一个区间持续3秒才抓取，避免在区间边界来回抖动
+		// cpu <= 10%  (1次/120秒，持续5s)
+		{Name: "normal_le100", Kinds: []int{DumpCPU}, Key: Dump100, Interval: 120, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.Window(stat.WindowCpuUsage).Stddev <= 50 && m.CpuUsage <= 100
+		}},
+		// 10% < cpu <= 30%  (1次/50秒，持续5s)
+		{Name: "normal_le300", Kinds: []int{DumpCPU}, Key: Dump300, Interval: 50, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.Window(stat.WindowCpuUsage).Stddev <= 50 && m.CpuUsage > 100 && m.CpuUsage <= 300
+		}},
+		// 30% < cpu <= 50%  (1次/30秒，持续5s)
+		{Name: "normal_le500", Kinds: []int{DumpCPU}, Key: Dump500, Interval: 30, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.Window(stat.WindowCpuUsage).Stddev <= 50 && m.CpuUsage > 300 && m.CpuUsage <= 500
+		}},
+		// 50% < cpu <= 70%  (1次/20秒，持续5s)
+		{Name: "normal_le700", Kinds: []int{DumpCPU}, Key: Dump700, Interval: 20, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.Window(stat.WindowCpuUsage).Stddev <= 50 && m.CpuUsage > 500 && m.CpuUsage <= 700
+		}},
+		// 70% < cpu (1次/6秒，持续5s)
+		{Name: "normal_le1000", Kinds: []int{DumpCPU}, Key: Dump800, Interval: 6, KeepTime: 5, Condition: func(m *stat.Metrics) bool {
+			return m.Window(stat.WindowCpuUsage).Stddev <= 50 && m.CpuUsage > 700
+		}},
+	}
+}
+
+// evaluateRules 检查所有规则，宿主机争抢cpu时跳过会抓取cpu剖析的规则
+func (d *dProf) evaluateRules(metrics *stat.Metrics, hostContention bool) {
+	now := time.Now()
+	skipped := false
+	for i := range d.rules {
+		rule := &d.rules[i]
+		state := &d.ruleStates[i]
+		if !state.check(rule, metrics, now) {
+			continue
+		}
+
+		// cpu剖析看到的只是被抢占后的样子，不算进入事件
+		if hostContention && rule.hasKind(DumpCPU) {
+			skipped = true
+			continue
+		}
+		started := false
+		for _, kind := range rule.Kinds {
+			if d.onTimePProf(kind, rule.Key, rule.Interval, rule.KeepTime, func() func() { return d.dump(kind, rule.Name) }) {
+				started = true
+			}
+		}
+		// 冷却中或同类剖析正在抓取时没有抓到，不算进入事件，下一次检查还会尝试
+		state.active = started && rule.Exit != nil
+	}
+
+	if skipped {
+		d.metrics.skipped.WithLabelValues(dumpKindNames[DumpCPU], SkipReasonHostBusy).Inc()
+	}
+}
+
+// validRules 去掉没有条件、剖析类型不支持或Samples、Count不合法的规则
+func (d *dProf) validRules(rules []Rule) []Rule {
+	valid := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Samples > 0 && rule.Count <= 0 {
+			rule.Count = rule.Samples
+		}
+
+		ok := rule.Condition != nil && len(rule.Kinds) > 0 && rule.Samples >= 0 && rule.Samples <= maxRuleSamples && rule.Count <= rule.Samples
+		for _, kind := range rule.Kinds {
+			if _, known := dumpKindNames[kind]; !known {
+				ok = false
+			}
+		}
+
+		if !ok {
+			d.logger.Warn("invalid rule, ignored", "rule", rule.Name)
+			continue
+		}
+		valid = append(valid, rule)
+	}
+
+	return valid
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRuleState(t *testing.T) {
+	above := func(threshold int64) func(m *stat.Metrics) bool {
+		return func(m *stat.Metrics) bool { return m.CpuUsage > threshold }
+	}
+	below := func(threshold int64) func(m *stat.Metrics) bool {
+		return func(m *stat.Metrics) bool { return m.CpuUsage < threshold }
+	}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		values []int64
+		want   []bool
+	}{
+		{"instant", Rule{Condition: above(300)},
+			[]int64{310, 290, 310},
+			[]bool{true, false, true}},
+		{"for", Rule{Condition: above(300), For: 2 * time.Second},
+			[]int64{310, 310, 290, 310, 310, 310},
+			[]bool{false, false, false, false, false, true}},
+		{"n of m", Rule{Condition: above(300), Samples: 4, Count: 3},
+			[]int64{310, 290, 310, 310, 310, 290, 290},
+			[]bool{false, false, false, true, true, true, false}},
+		{"hysteresis", Rule{Condition: above(300), Exit: below(250)},
+			[]int64{310, 310, 290, 310, 240, 310},
+			[]bool{true, false, false, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state ruleState
+			now := time.Now()
+			for i, value := range tt.values {
+				got := state.check(&tt.rule, &stat.Metrics{CpuUsage: value}, now)
+				if got != tt.want[i] {
+					t.Fatalf("sample %d (%d): got %v, want %v", i, value, got, tt.want[i])
+				}
+				// evaluateRules抓取后进入事件
+				if got {
+					state.active = tt.rule.Exit != nil
+				}
+				now = now.Add(time.Second)
+			}
+		})
+	}
+}
+
+func TestEvaluateRules_Cooldown(t *testing.T) {
+	d := &dProf{
+		options:   Options{DumpDir: t.TempDir()},
+		logger:    slog.Default(),
+		isDoing:   make(map[int]bool),
+		timers:    map[int]map[int]int64{DumpGoroutine: make(map[int]int64)},
+		baselines: make(map[string]*baseline),
+	}
+	d.metrics, _ = newSelfMetrics("test", nil, d.options.DumpDir)
+	d.rules = []Rule{{
+		Name:      "threads",
+		Kinds:     []int{DumpGoroutine},
+		Key:       DumpThreads,
+		Interval:  300,
+		Condition: func(m *stat.Metrics) bool { return m.Process.Threads >= 1000 },
+		Exit:      func(m *stat.Metrics) bool { return m.Process.Threads < 800 },
+	}}
+	d.ruleStates = make([]ruleState, len(d.rules))
+
+	// 冷却中没有抓取，不算进入事件
+	d.timers[DumpGoroutine][DumpThreads] = time.Now().Unix()
+	m := &stat.Metrics{}
+	m.Process.Threads = 1200
+	d.evaluateRules(m, false)
+	if d.ruleStates[0].active {
+		t.Fatal("rule should not be active when the capture was skipped by cooldown")
+	}
+
+	// 冷却结束后下一次检查就会抓取，之后处于事件中
+	d.timers[DumpGoroutine][DumpThreads] = time.Now().Unix() - 300
+	d.evaluateRules(m, false)
+	if !d.ruleStates[0].active {
+		t.Fatal("rule should be active after a capture started")
+	}
+
+	// 等待抓取写完文件，避免临时目录被清理时还在写
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		d.mu.Lock()
+		doing := d.isDoing[DumpGoroutine]
+		d.mu.Unlock()
+		if !doing {
+			break
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	crashLoopWindow = 5 * time.Minute // 统计重启次数的时间窗口
+	crashLoopStarts = 3               // 窗口内启动次数达到后认为是崩溃循环
+	crashLoopDelay  = 3 * time.Second // 崩溃循环时，启动后多久抓取快照
+	maxStartTimes   = 16              // 最多保存的启动时间数
+)
+
+// profilerState 持久化到dump目录的状态，进程重启后冷却时间依然有效
+type profilerState struct {
+	Timers          map[string]map[int]int64 `json:"timers"`              // 剖析类型 => key => 上一次抓取的时间
+	StartTimes      []int64                  `json:"start_times"`         // 最近几次启动的时间
+	RestartCount    int                      `json:"restart_count"`       // 累计重启次数
+	LastCrashReport string                   `json:"last_crash_report"`   // 最近一次崩溃报告的路径
+	Baselines       map[string]*baseline     `json:"baselines,omitempty"` // 异常检测的基线
+
+	seq uint64 // 快照的序号，不会用旧的快照覆盖新的
+}
+
+// statePath 状态文件的路径，不以 程序名- 开头，不算dump文件
+func (d *dProf) statePath() string {
+	return filepath.Join(d.options.DumpDir, fmt.Sprintf("%s.dprof-state.json", getAppName()))
+}
+
+// legacyStatePath 旧版本的状态文件路径，读取后删除
+func (d *dProf) legacyStatePath() string {
+	return filepath.Join(d.options.DumpDir, fmt.Sprintf("%s-dprof-state.json", getAppName()))
+}
+
+// loadState 启动时读取上一次运行的状态，恢复冷却时间，记录本次启动并判断是否处于崩溃循环
+func (d *dProf) loadState() {
+	var saved *profilerState
+	defer func() {
+		d.saveState(saved)
+		_ = os.Remove(d.legacyStatePath())
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var state profilerState
+	data, err := os.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(d.legacyStatePath())
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		d.logger.Error("load state failed", "path", d.statePath(), "err", err)
+	}
+
+	// 恢复冷却时间
+	for kind, name := range dumpKindNames {
+		for key, t := range state.Timers[name] {
+			d.timers[kind][key] = t
+		}
+	}
+
+	// 恢复基线，不用重新学习
+	for name, b := range state.Baselines {
+		if _, ok := d.baselines[name]; ok && b != nil {
+			d.baselines[name] = b
+		}
+	}
+
+	// 记录本次启动
+	now := time.Now()
+	if len(state.StartTimes) > 0 {
+		state.RestartCount++
+	}
+	state.StartTimes = append(state.StartTimes, now.Unix())
+	if len(state.StartTimes) > maxStartTimes {
+		state.StartTimes = state.StartTimes[len(state.StartTimes)-maxStartTimes:]
+	}
+
+	var recentStarts int
+	for _, t := range state.StartTimes {
+		if now.Sub(time.Unix(t, 0)) <= crashLoopWindow {
+			recentStarts++
+		}
+	}
+	d.crashLoop = recentStarts >= crashLoopStarts
+
+	if d.lastCrashReport == "" {
+		d.lastCrashReport = state.LastCrashReport
+	}
+	d.startTimes = state.StartTimes
+	d.restartCount = state.RestartCount
+
+	d.metrics.restarts.Set(float64(d.restartCount))
+	if d.crashLoop {
+		d.metrics.crashLoop.Set(1)
+		d.logger.Warn("crash loop detected", "starts", recentStarts, "window", crashLoopWindow, "last_crash_report", d.lastCrashReport)
+	}
+
+	saved = d.stateLocked()
+}
+
+// stateLocked 拷贝一份需要持久化的状态，调用时需要持有d.mu，拷贝后在锁外用saveState写文件
+func (d *dProf) stateLocked() *profilerState {
+	d.stateSeq++
+	state := &profilerState{
+		Timers:          make(map[string]map[int]int64, len(d.timers)),
+		StartTimes:      append([]int64(nil), d.startTimes...),
+		RestartCount:    d.restartCount,
+		LastCrashReport: d.lastCrashReport,
+		Baselines:       make(map[string]*baseline, len(d.baselines)),
+		seq:             d.stateSeq,
+	}
+	for kind, timers := range d.timers {
+		copied := make(map[int]int64, len(timers))
+		for key, t := range timers {
+			copied[key] = t
+		}
+		state.Timers[dumpKindNames[kind]] = copied
+	}
+	for name, b := range d.baselines {
+		copied := *b
+		copied.Hours = append([]ewmaStat(nil), b.Hours...)
+		state.Baselines[name] = &copied
+	}
+
+	return state
+}
+
+// saveState 保存stateLocked拷贝的状态，不持有d.mu，避免写文件时阻塞抓取和规则检查
+func (d *dProf) saveState(state *profilerState) {
+	if state == nil {
+		return
+	}
+
+	d.stateFileMu.Lock()
+	defer d.stateFileMu.Unlock()
+
+	if state.seq <= d.savedStateSeq {
+		return
+	}
+	d.savedStateSeq = state.seq
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		d.logger.Error("save state failed", "path", d.statePath(), "err", err)
+		return
+	}
+
+	// 先写临时文件再重命名，避免崩溃时留下写了一半的文件
+	err = os.MkdirAll(d.options.DumpDir, 0755)
+	if err == nil {
+		err = os.WriteFile(d.statePath()+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(d.statePath()+".tmp", d.statePath())
+	}
+	if err != nil {
+		d.logger.Error("save state failed", "path", d.statePath(), "err", err)
+	}
+}
+
+// startCrashLoopPolicy 崩溃循环时，启动后尽早抓取堆和协程快照，进程可能很快又会崩溃
+func (d *dProf) startCrashLoopPolicy() {
+	if !d.crashLoop {
+		return
+	}
+
+	time.AfterFunc(crashLoopDelay, func() {
+		d.onTimePProf(DumpMEM, DumpCrashLoop, 600, 0, func() func() { return d.dumpHeapProfile("crash_loop") })
+		d.onTimePProf(DumpGoroutine, DumpCrashLoop, 600, 0, func() func() { return d.dumpGoroutineProfile("crash_loop") })
+	})
+}
@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dan-and-dna/dprof/stat"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	tcpMaxPorts = 32 // 最多按多少个监听端口统计，避免指标基数过高
+
+	closeWaitLeakWindow         = 3 * time.Minute // 观察窗口
+	closeWaitLeakMinSlope       = 0.1             // 窗口内最小增长斜率，单位 个/秒，即每分钟6个，按采集间隔换算成 个/采样
+	closeWaitLeakMonotonicRatio = 0.9             // 窗口内不下降的采样占比
+)
+
+var (
+	ErrorBadSockstat = errors.New("bad /proc/net/sockstat")
+)
+
+// TcpSampler 按状态和本地端口统计当前进程的tcp连接，CLOSE_WAIT持续增长一般是连接没有关闭
+type TcpSampler struct {
+	fdPath         string
+	tablePaths     []string // tcp、tcp6
+	sockstatPath   string
+	reader         procReader
+	fds            fdDir
+	inodes         map[uint64]struct{}
+	sockets        []tcpSocket
+	interval       time.Duration
+	closeWaitTrend *stat.TrendDetector
+}
+
+func NewTcpSampler() *TcpSampler {
+	s := &TcpSampler{
+		fdPath:       path.Join(procSelfPath, "fd"),
+		tablePaths:   []string{path.Join(procSelfPath, "net", "tcp"), path.Join(procSelfPath, "net", "tcp6")},
+		sockstatPath: path.Join(procSelfPath, "net", "sockstat"),
+		inodes:       make(map[uint64]struct{}),
+	}
+	s.SetInterval(5 * time.Second)
+
+	return s
+}
+
+// SetInterval 按实际的采集间隔换算CLOSE_WAIT泄漏检测的窗口和斜率阈值
+func (s *TcpSampler) SetInterval(interval time.Duration) {
+	s.interval = interval
+	s.closeWaitTrend = newLeakTrend(closeWaitLeakWindow, closeWaitLeakMinSlope, closeWaitLeakMonotonicRatio, interval)
+}
+
+func (s *TcpSampler) Name() string {
+	return "tcp"
+}
+
+// Sample 通过fd找到当前进程的socket；TIME_WAIT的连接已经不属于任何进程，
+// /proc/net/tcp又是整个网络命名空间的，按端口归属会把同一网络中其他进程(比如host网络或SO_REUSEPORT)的算进来，只看sockstat中的tw
+func (s *TcpSampler) Sample(m *stat.Metrics) error {
+	clear(s.inodes)
+	if err := s.fds.socketInodes(s.fdPath, s.inodes); err != nil {
+		return err
+	}
+
+	// 连接多时socket表很大，复用缓冲区和解析结果
+	sockets := s.sockets[:0]
+	for _, tablePath := range s.tablePaths {
+		data, err := s.reader.read(tablePath)
+		if err != nil {
+			// 没有开启ipv6
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		sockets, err = parseTcpTable(data, sockets)
+		if err != nil {
+			return err
+		}
+	}
+	s.sockets = sockets
+
+	tcp := countTcpSockets(sockets, s.inodes)
+	tcp.CloseWaitLeakSuspected = s.closeWaitTrend.Add(tcp.States["CLOSE_WAIT"])
+	tcp.CloseWaitSlope = s.closeWaitTrend.Slope / s.interval.Seconds()
+
+	// 网络命名空间范围的统计，容器里一般就是当前进程
+	if data, err := s.reader.read(s.sockstatPath); err == nil {
+		if sockstat, err := parseSockstat(data); err == nil {
+			tcp.Sockstat = sockstat
+		}
+	}
+
+	m.Tcp = tcp
+
+	return nil
+}
+
+// tcpSocket 统计用的tcp socket，只有状态、本地端口和inode，解析时不生成字符串
+type tcpSocket struct {
+	state uint8 // /proc/net/tcp中的st列
+	port  uint16
+	inode uint64
+}
+
+// parseTcpTable 解析/proc/net/tcp、tcp6的内容追加到sockets，格式同ReadSocketTable
+func parseTcpTable(data []byte, sockets []tcpSocket) ([]tcpSocket, error) {
+	// 表头
+	_, data = nextLine(data)
+
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+
+		var fields [10][]byte
+		count := 0
+		for count < len(fields) {
+			fields[count], line = stat.NextField(line)
+			if len(fields[count]) == 0 {
+				break
+			}
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		if count < len(fields) {
+			return sockets, ErrorBadSocketTable
+		}
+
+		i := bytes.LastIndexByte(fields[1], ':')
+		if i < 0 {
+			return sockets, ErrorBadSocketTable
+		}
+		port, ok := parseHex(fields[1][i+1:])
+		if !ok || port > math.MaxUint16 {
+			return sockets, ErrorBadSocketTable
+		}
+		st, ok := parseHex(fields[3])
+		if !ok || st > math.MaxUint8 {
+			return sockets, ErrorBadSocketTable
+		}
+		inode, ok := stat.ParseUint(fields[9])
+		if !ok {
+			return sockets, ErrorBadSocketTable
+		}
+
+		sockets = append(sockets, tcpSocket{state: uint8(st), port: uint16(port), inode: inode})
+	}
+
+	return sockets, nil
+}
+
+// countTcpSockets 按状态和本地端口统计当前进程持有的socket，不是监听端口的连接归到client
+func countTcpSockets(sockets []tcpSocket, inodes map[uint64]struct{}) stat.TcpStat {
+	tcp := stat.TcpStat{
+		Available: true,
+		States:    make(map[string]int),
+	}
+
+	owned := func(socket tcpSocket) bool {
+		_, ok := inodes[socket.inode]
+		return ok && socket.inode != 0
+	}
+
+	// 当前进程的监听端口，端口数多时只保留小的端口
+	var ports []int
+	for _, socket := range sockets {
+		if tcpStates[uint64(socket.state)] == "LISTEN" && owned(socket) {
+			ports = append(ports, int(socket.port))
+		}
+	}
+	sort.Ints(ports)
+	listening := make(map[uint16]string)
+	for _, p := range ports {
+		if len(listening) >= tcpMaxPorts {
+			break
+		}
+		if _, ok := listening[uint16(p)]; !ok {
+			listening[uint16(p)] = strconv.Itoa(p)
+		}
+	}
+
+	counts := make(map[stat.TcpSocketKey]int)
+	for _, socket := range sockets {
+		if !owned(socket) {
+			continue
+		}
+
+		port, isListening := listening[socket.port]
+		if !isListening {
+			port = "client"
+		}
+
+		state := tcpStates[uint64(socket.state)]
+		tcp.States[state]++
+		counts[stat.TcpSocketKey{State: state, Port: port}]++
+	}
+	tcp.Sockets = counts
+
+	return tcp
+}
+
+/*
+ReadSockstat 读取/proc/net/sockstat中tcp的统计，mem的单位为页
+
+	sockets: used 18
+	TCP: inuse 4 orphan 0 tw 0 alloc 4 mem 0
+	UDP: inuse 0 mem 0
+*/
+func ReadSockstat(filename string) (stat.Sockstat, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return stat.Sockstat{}, err
+	}
+
+	return parseSockstat(data)
+}
+
+// parseSockstat 解析/proc/net/sockstat的内容，不分配内存
+func parseSockstat(data []byte) (stat.Sockstat, error) {
+	var sockstat stat.Sockstat
+
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+
+		name, rest := stat.NextField(line)
+		if string(name) != "TCP:" {
+			continue
+		}
+
+		for {
+			var key, value []byte
+			key, rest = stat.NextField(rest)
+			value, rest = stat.NextField(rest)
+			if len(value) == 0 {
+				break
+			}
+
+			val, ok := stat.ParseUint(value)
+			if !ok {
+				return sockstat, ErrorBadSockstat
+			}
+
+			switch string(key) {
+			case "inuse":
+				sockstat.InUse = val
+			case "orphan":
+				sockstat.Orphan = val
+			case "tw":
+				sockstat.TimeWait = val
+			case "alloc":
+				sockstat.Alloc = val
+			case "mem":
+				sockstat.MemPages = val
+			}
+		}
+		sockstat.Available = true
+	}
+
+	return sockstat, nil
+}
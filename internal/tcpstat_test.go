@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"github.com/dan-and-dna/dprof/stat"
+	"testing"
+)
+
+func TestCountTcpSockets(t *testing.T) {
+	sockets := []tcpSocket{
+		{state: 0x0A, port: 8080, inode: 1}, // LISTEN
+		{state: 0x01, port: 8080, inode: 2}, // ESTABLISHED
+		{state: 0x08, port: 8080, inode: 3}, // CLOSE_WAIT
+		{state: 0x06, port: 8080, inode: 0}, // TIME_WAIT
+		{state: 0x01, port: 40000, inode: 4},
+		// 其他进程的连接
+		{state: 0x01, port: 9090, inode: 100},
+		{state: 0x06, port: 41000, inode: 0},
+	}
+	inodes := map[uint64]struct{}{1: {}, 2: {}, 3: {}, 4: {}}
+
+	tcp := countTcpSockets(sockets, inodes)
+
+	// TIME_WAIT不属于任何进程，不统计
+	wantStates := map[string]int{"LISTEN": 1, "ESTABLISHED": 2, "CLOSE_WAIT": 1}
+	for state, want := range wantStates {
+		if tcp.States[state] != want {
+			t.Errorf("%s: got %d, want %d", state, tcp.States[state], want)
+		}
+	}
+	if len(tcp.States) != len(wantStates) {
+		t.Errorf("got states %v", tcp.States)
+	}
+
+	if n := tcp.Sockets[stat.TcpSocketKey{State: "ESTABLISHED", Port: "8080"}]; n != 1 {
+		t.Errorf("ESTABLISHED 8080: got %d, want 1", n)
+	}
+	if n := tcp.Sockets[stat.TcpSocketKey{State: "ESTABLISHED", Port: "client"}]; n != 1 {
+		t.Errorf("ESTABLISHED client: got %d, want 1", n)
+	}
+}
+
+func TestReadSockstat(t *testing.T) {
+	path := writeProcFile(t, "sockets: used 18\nTCP: inuse 4 orphan 1 tw 2 alloc 5 mem 3\nUDP: inuse 0 mem 0\n")
+
+	sockstat, err := ReadSockstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := stat.Sockstat{Available: true, InUse: 4, Orphan: 1, TimeWait: 2, Alloc: 5, MemPages: 3}
+	if sockstat != want {
+		t.Errorf("got %+v, want %+v", sockstat, want)
+	}
+}
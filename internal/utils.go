@@ -2,6 +2,9 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
+	"github.com/dan-and-dna/dprof/stat"
+	"io"
 	"os"
 	"strings"
 )
@@ -52,3 +55,114 @@ func ReadLine(filename string) (string, error) {
 
 	return "", scanner.Err()
 }
+
+/*
+procReader 复用缓冲区读取/proc和cgroup的文件，文件一直打开，每次从偏移0读都会重新生成内容，读取时不分配内存；
+零值可以直接使用，不能并发使用
+*/
+type procReader struct {
+	files map[string]*os.File
+	buf   []byte
+}
+
+// read 读取整个文件，返回的内容在下一次read之前有效；缓冲区放不下时翻倍后重新读
+func (r *procReader) read(filename string) ([]byte, error) {
+	f, ok := r.files[filename]
+	if !ok {
+		var err error
+		f, err = os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.files == nil {
+			r.files = make(map[string]*os.File)
+		}
+		r.files[filename] = f
+	}
+
+	if r.buf == nil {
+		r.buf = make([]byte, 4096)
+	}
+
+	for {
+		n, err := f.ReadAt(r.buf, 0)
+		if err == io.EOF {
+			return r.buf[:n], nil
+		}
+		if err != nil {
+			// cgroup可能被删除或移动，下一次重新打开
+			_ = f.Close()
+			delete(r.files, filename)
+			return nil, err
+		}
+
+		r.buf = make([]byte, len(r.buf)*2)
+	}
+}
+
+// nextLine 返回第一行和剩下的内容
+func nextLine(data []byte) ([]byte, []byte) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return data, nil
+	}
+
+	return data[:i], data[i+1:]
+}
+
+// parseHex 不分配内存的十六进制无符号整数解析
+func parseHex(b []byte) (uint64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var v uint64
+	for _, c := range b {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 10
+		case c >= 'A' && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		v = v<<4 | uint64(c)
+	}
+
+	return v, true
+}
+
+/*
+lookupUint 在每行为 key value 格式的内容中查找key的值，比如cgroup的cpu.stat、/proc/self/io，
+key后面的冒号会去掉，不分配内存
+
+	nr_periods 100
+	nr_throttled 5
+	rchar: 3980
+*/
+func lookupUint(data []byte, key string) (uint64, bool) {
+	for len(data) > 0 {
+		var line []byte
+		line, data = nextLine(data)
+
+		field, rest := stat.NextField(line)
+		if n := len(field); n > 0 && field[n-1] == ':' {
+			field = field[:n-1]
+		}
+		if string(field) != key {
+			continue
+		}
+
+		value, rest := stat.NextField(rest)
+		if field, _ := stat.NextField(rest); len(field) != 0 {
+			continue
+		}
+
+		return stat.ParseUint(value)
+	}
+
+	return 0, false
+}
@@ -0,0 +1,60 @@
+package dprof
+
+import (
+	"github.com/dan-and-dna/dprof/internal"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+/*
+Recover 恢复panic并输出崩溃报告，必须直接defer调用
+
+	defer dprof.Recover()
+*/
+func Recover() {
+	if value := recover(); value != nil {
+		onPanic(value)
+	}
+}
+
+// Go 启动协程，协程中的panic会被恢复并输出崩溃报告
+func Go(fn func()) {
+	go func() {
+		defer Recover()
+		fn()
+	}()
+}
+
+// Middleware 恢复处理请求时的panic并输出崩溃报告，没有开启Repanic时返回500
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+
+			// net/http 用来中断请求的panic，不是错误
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+
+			onPanic(value)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// onPanic dprof还没有初始化时只输出日志，不会用默认配置初始化，避免调用者之后的配置被忽略
+func onPanic(value interface{}) {
+	d := internal.LookupSingleInst()
+	if d == nil {
+		slog.Error("panic recovered before dprof initialized", "value", value, "stack", string(debug.Stack()))
+		return
+	}
+
+	d.OnPanic(value)
+}
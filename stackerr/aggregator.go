@@ -0,0 +1,177 @@
+package stackerr
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxSites = 1000
+	otherSite       = "other" // 超过maxSites后新出现的位置都归到这里
+)
+
+var _ prometheus.Collector = (*Aggregator)(nil)
+
+// Sample 某个错误位置的一次采样
+type Sample struct {
+	Time    time.Time
+	Message string
+	Fields  []Field
+}
+
+// Site 一个错误位置的统计
+type Site struct {
+	Fingerprint string
+	Function    string // 出错位置的函数
+	Line        int    // 出错位置的行号
+	Count       uint64
+	First       Sample // 第一次出现
+	Last        Sample // 最近一次出现
+}
+
+// Aggregator 按指纹统计错误出现的次数，只保留每个位置的第一次和最近一次采样
+type Aggregator struct {
+	depth    int
+	maxSites int
+
+	mu    sync.Mutex
+	sites map[string]*Site
+
+	descErrors    *prometheus.Desc
+	descFirstSeen *prometheus.Desc
+}
+
+// AggregatorOption Aggregator的配置项
+type AggregatorOption func(a *aggregatorOptions)
+
+type aggregatorOptions struct {
+	namespace   string
+	constLabels prometheus.Labels
+	depth       int
+	maxSites    int
+}
+
+// WithMetricsNamespace 指标名前缀
+func WithMetricsNamespace(namespace string) AggregatorOption {
+	return func(opts *aggregatorOptions) {
+		opts.namespace = namespace
+	}
+}
+
+// WithMetricsConstLabels 所有指标都带上的固定label
+func WithMetricsConstLabels(labels prometheus.Labels) AggregatorOption {
+	return func(opts *aggregatorOptions) {
+		opts.constLabels = labels
+	}
+}
+
+// WithFingerprintDepth 计算指纹使用的调用栈帧数，默认为5
+func WithFingerprintDepth(depth int) AggregatorOption {
+	return func(opts *aggregatorOptions) {
+		opts.depth = depth
+	}
+}
+
+// WithMaxSites 最多统计的错误位置数，避免指标基数过高，默认为1000
+func WithMaxSites(maxSites int) AggregatorOption {
+	return func(opts *aggregatorOptions) {
+		opts.maxSites = maxSites
+	}
+}
+
+func NewAggregator(opts ...AggregatorOption) *Aggregator {
+	options := aggregatorOptions{
+		depth:    defaultFingerprintDepth,
+		maxSites: defaultMaxSites,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	labels := []string{"fingerprint", "function", "line"}
+	return &Aggregator{
+		depth:    options.depth,
+		maxSites: options.maxSites,
+		sites:    make(map[string]*Site),
+
+		descErrors: prometheus.NewDesc(prometheus.BuildFQName(options.namespace, "stackerr", "errors_total"),
+			"按出错位置统计的错误次数", labels, options.constLabels),
+		descFirstSeen: prometheus.NewDesc(prometheus.BuildFQName(options.namespace, "stackerr", "first_seen_timestamp_seconds"),
+			"出错位置第一次出现的时间", labels, options.constLabels),
+	}
+}
+
+// Observe 统计一次错误，返回错误的指纹；错误链上没有调用栈的错误不统计
+func (a *Aggregator) Observe(err error) string {
+	fingerprint := Fingerprint(err, a.depth)
+	if fingerprint == "" {
+		return ""
+	}
+
+	sample := Sample{Time: time.Now(), Message: err.Error(), Fields: Fields(err)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	site, ok := a.sites[fingerprint]
+	if !ok {
+		if len(a.sites) >= a.maxSites {
+			fingerprint = otherSite
+			site, ok = a.sites[fingerprint]
+		}
+
+		if !ok {
+			site = &Site{Fingerprint: fingerprint, First: sample}
+			if fingerprint != otherSite {
+				if frames := Origin(err).StackTrace(); len(frames) > 0 {
+					site.Function = frames[0].Function
+					site.Line = frames[0].Line
+				}
+			}
+			a.sites[fingerprint] = site
+		}
+	}
+
+	site.Count++
+	site.Last = sample
+
+	return fingerprint
+}
+
+// Sites 返回所有错误位置的统计，按第一次出现的时间从新到旧排序
+func (a *Aggregator) Sites() []Site {
+	a.mu.Lock()
+	sites := make([]Site, 0, len(a.sites))
+	for _, site := range a.sites {
+		sites = append(sites, *site)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].First.Time.After(sites[j].First.Time)
+	})
+
+	return sites
+}
+
+func (a *Aggregator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.descErrors
+	ch <- a.descFirstSeen
+}
+
+func (a *Aggregator) Collect(ch chan<- prometheus.Metric) {
+	for _, site := range a.Sites() {
+		line := ""
+		if site.Line > 0 {
+			line = strconv.Itoa(site.Line)
+		}
+
+		ch <- prometheus.MustNewConstMetric(a.descErrors, prometheus.CounterValue, float64(site.Count),
+			site.Fingerprint, site.Function, line)
+		ch <- prometheus.MustNewConstMetric(a.descFirstSeen, prometheus.GaugeValue, float64(site.First.Time.Unix()),
+			site.Fingerprint, site.Function, line)
+	}
+}
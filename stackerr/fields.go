@@ -0,0 +1,165 @@
+package stackerr
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+)
+
+var _ json.Marshaler = (*StackErr)(nil)
+var _ slog.LogValuer = (*StackErr)(nil)
+
+// Field 错误附带的结构化信息
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// With 给err附带结构化信息，keyvals为 key1, value1, key2, value2...，err为nil时返回nil
+func With(err error, keyvals ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	stackErr := &StackErr{cause: err, fields: toFields(keyvals)}
+
+	// 错误链上已经有调用栈就不再记录
+	var inner *StackErr
+	if !errors.As(err, &inner) {
+		stackErr.init()
+	}
+
+	return stackErr
+}
+
+// Fields 返回错误链上所有的结构化信息，内层在前，外层在后
+func Fields(err error) []Field {
+	var chain []*StackErr
+	for err != nil {
+		if stackErr, ok := err.(*StackErr); ok {
+			chain = append(chain, stackErr)
+		}
+		err = errors.Unwrap(err)
+	}
+
+	var fields []Field
+	for i := len(chain) - 1; i >= 0; i-- {
+		fields = append(fields, chain[i].fields...)
+	}
+
+	return fields
+}
+
+// toFields 与slog一样，落单的值使用!BADKEY作为key
+func toFields(keyvals []interface{}) []Field {
+	fields := make([]Field, 0, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok || i+1 == len(keyvals) {
+			fields = append(fields, Field{Key: "!BADKEY", Value: keyvals[i]})
+			i--
+			continue
+		}
+
+		fields = append(fields, Field{Key: key, Value: keyvals[i+1]})
+	}
+
+	return fields
+}
+
+type jsonFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+type jsonError struct {
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	Frames  []jsonFrame            `json:"frames,omitempty"`
+	Cause   interface{}            `json:"cause,omitempty"`
+}
+
+/*
+MarshalJSON 输出错误信息、本层的结构化信息、调用栈和被包装的错误
+
+	{
+		"message": "load config: open a.txt: no such file or directory",
+		"fields": {"user_id": 1},
+		"frames": [{"function": "main.load", "file": "/app/main.go", "line": 10}],
+		"cause": {"message": "open a.txt: no such file or directory"}
+	}
+*/
+func (err *StackErr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(err.toJSON())
+}
+
+func (err *StackErr) toJSON() *jsonError {
+	j := &jsonError{Message: err.Error()}
+
+	if len(err.fields) > 0 {
+		j.Fields = make(map[string]interface{}, len(err.fields))
+		for _, field := range err.fields {
+			j.Fields[field.Key] = field.Value
+		}
+	}
+
+	for _, frame := range err.StackTrace() {
+		j.Frames = append(j.Frames, jsonFrame(frame))
+	}
+
+	if err.cause != nil {
+		j.Cause = causeToJSON(err.cause)
+	}
+
+	return j
+}
+
+// causeToJSON 沿着errors.Unwrap往里找，fmt.Errorf("%w")等包装里面的StackErr也输出调用栈和结构化信息
+func causeToJSON(cause error) *jsonError {
+	if stackErr, ok := cause.(*StackErr); ok {
+		return stackErr.toJSON()
+	}
+
+	j := &jsonError{Message: cause.Error()}
+	if inner := errors.Unwrap(cause); inner != nil {
+		j.Cause = causeToJSON(inner)
+	}
+
+	return j
+}
+
+// LogValue 实现slog.LogValuer，输出错误信息、错误链上所有的结构化信息和最内层的调用栈
+func (err *StackErr) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("msg", err.Error())}
+
+	fields := Fields(err)
+	if len(fields) > 0 {
+		fieldAttrs := make([]interface{}, 0, len(fields))
+		for _, field := range fields {
+			fieldAttrs = append(fieldAttrs, slog.Any(field.Key, field.Value))
+		}
+		attrs = append(attrs, slog.Group("fields", fieldAttrs...))
+	}
+
+	if cause := Cause(err); cause != error(err) {
+		attrs = append(attrs, slog.String("cause", cause.Error()))
+	}
+
+	// 最内层的调用栈最接近出错的位置
+	var frames []string
+	for e := error(err); e != nil; e = errors.Unwrap(e) {
+		if stackErr, ok := e.(*StackErr); ok && len(stackErr.pcs) > 0 {
+			frames = frames[:0]
+			for _, frame := range stackErr.StackTrace() {
+				frames = append(frames, frame.Function+" "+frame.File+":"+strconv.Itoa(frame.Line))
+			}
+		}
+	}
+	if len(frames) > 0 {
+		attrs = append(attrs, slog.Any("frames", frames))
+	}
+
+	return slog.GroupValue(attrs...)
+}
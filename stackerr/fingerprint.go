@@ -0,0 +1,52 @@
+package stackerr
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+)
+
+const (
+	defaultFingerprintDepth = 5
+)
+
+// Fingerprint 按错误产生的位置计算指纹，取错误链上最内层的调用栈的前depth帧的函数名和行号做hash，
+// 文件路径与编译环境有关所以不参与计算；错误链上没有调用栈时返回空字符串
+func Fingerprint(err error, depth int) string {
+	origin := Origin(err)
+	if origin == nil {
+		return ""
+	}
+
+	if depth <= 0 {
+		depth = defaultFingerprintDepth
+	}
+
+	frames := origin.StackTrace()
+	if len(frames) > depth {
+		frames = frames[:depth]
+	}
+
+	h := fnv.New64a()
+	for _, frame := range frames {
+		_, _ = h.Write([]byte(frame.Function))
+		_, _ = h.Write([]byte{':'})
+		_, _ = h.Write([]byte(strconv.Itoa(frame.Line)))
+		_, _ = h.Write([]byte{'\n'})
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// Origin 返回错误链上最内层带调用栈的StackErr，即最接近出错位置的那个
+func Origin(err error) *StackErr {
+	var origin *StackErr
+	for err != nil {
+		if stackErr, ok := err.(*StackErr); ok && len(stackErr.pcs) > 0 {
+			origin = stackErr
+		}
+		err = errors.Unwrap(err)
+	}
+
+	return origin
+}
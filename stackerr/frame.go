@@ -0,0 +1,73 @@
+package stackerr
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultMaxDepth = 32
+)
+
+var (
+	maxDepth   atomic.Int32
+	frameCache sync.Map // pc => []Frame，内联的函数一个pc会对应多个Frame
+)
+
+func init() {
+	maxDepth.Store(defaultMaxDepth)
+}
+
+// Frame 调用栈中的一帧
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// SetMaxDepth 设置记录调用栈的最大深度，默认为32，只影响之后创建的错误
+func SetMaxDepth(depth int) {
+	if depth <= 0 {
+		depth = defaultMaxDepth
+	}
+	maxDepth.Store(int32(depth))
+}
+
+// callers 只记录pc，不做符号解析
+func callers(skip int) []uintptr {
+	pcs := make([]uintptr, maxDepth.Load())
+	n := runtime.Callers(skip, pcs)
+	return pcs[:n]
+}
+
+// framesOf 把pc解析成Frame，解析结果按pc缓存
+func framesOf(pcs []uintptr) []Frame {
+	frames := make([]Frame, 0, len(pcs))
+	for _, pc := range pcs {
+		if cached, ok := frameCache.Load(pc); ok {
+			frames = append(frames, cached.([]Frame)...)
+			continue
+		}
+
+		var pcFrames []Frame
+		callersFrames := runtime.CallersFrames([]uintptr{pc})
+		for {
+			frame, more := callersFrames.Next()
+			pcFrames = append(pcFrames, Frame{
+				Function: frame.Function,
+				File:     frame.File,
+				Line:     frame.Line,
+			})
+
+			if !more {
+				break
+			}
+		}
+
+		frameCache.Store(pc, pcFrames)
+		frames = append(frames, pcFrames...)
+	}
+
+	return frames
+}
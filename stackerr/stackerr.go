@@ -1,49 +1,181 @@
 package stackerr
 
 import (
+	"errors"
 	"fmt"
-	"runtime"
+	"io"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 var _ error = (*StackErr)(nil)
+var _ fmt.Formatter = (*StackErr)(nil)
 
 type StackErr struct {
-	strError string
+	msg    string
+	cause  error
+	fields []Field   // 结构化信息
+	pcs    []uintptr // 创建时的调用栈，用到时才解析成Frame
+
+	stackOnce sync.Once
+	stack     string
+}
+
+// New 创建带有调用栈的错误，msg可以为空
+func New(msg ...string) error {
+	err := &StackErr{msg: strings.Join(msg, " ")}
+	err.init()
+
+	return err
 }
 
-func New() error {
+// Errorf 与fmt.Errorf一样，支持%w包装错误
+func Errorf(format string, args ...interface{}) error {
+	wrapped := fmt.Errorf(format, args...)
+
 	err := &StackErr{}
+	switch wrapped.(type) {
+	case interface{ Unwrap() error }, interface{ Unwrap() []error }:
+		// 使用了%w，保留fmt包装的错误，errors.Is和errors.As可以继续往下找
+		err.cause = wrapped
+	default:
+		err.msg = wrapped.Error()
+	}
 	err.init()
 
 	return err
 }
 
-func (err *StackErr) init() {
-	pc := make([]uintptr, 10)
-	n := runtime.Callers(3, pc)
-	if n == 0 {
-		return
+// Wrap 包装err并记录调用栈，err为nil时返回nil
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
 	}
 
-	b := strings.Builder{}
-	pc = pc[:n]
-	frames := runtime.CallersFrames(pc)
+	stackErr := &StackErr{msg: msg, cause: err}
+	stackErr.init()
 
-	for {
-		frame, more := frames.Next()
-		b.WriteString(fmt.Sprintf("%s()\n", frame.Function))
-		b.WriteString(fmt.Sprintf("	%s:%d\n", frame.File, frame.Line))
+	return stackErr
+}
 
-		if !more {
-			break
+// Wrapf 与Wrap一样，msg支持格式化
+func Wrapf(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	stackErr := &StackErr{msg: fmt.Sprintf(format, args...), cause: err}
+	stackErr.init()
+
+	return stackErr
+}
+
+// Cause 沿着Unwrap一直找到最底层的错误
+func Cause(err error) error {
+	for err != nil {
+		cause := errors.Unwrap(err)
+		if cause == nil {
+			return err
 		}
+		err = cause
 	}
 
-	err.strError = b.String()
+	return err
+}
+
+// Is 同errors.Is，方便从pkg/errors迁移
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As 同errors.As
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
+// Unwrap 同errors.Unwrap
+func Unwrap(err error) error {
+	return errors.Unwrap(err)
+}
 
+func (err *StackErr) init() {
+	err.pcs = callers(4)
 }
 
+// Error 返回错误信息，包含被包装的错误，格式为 msg: cause；没有任何信息时返回调用栈
 func (err *StackErr) Error() string {
-	return err.strError
+	switch {
+	case err.cause == nil && err.msg == "":
+		return err.Stack()
+	case err.cause == nil:
+		return err.msg
+	case err.msg == "":
+		return err.cause.Error()
+	default:
+		return err.msg + ": " + err.cause.Error()
+	}
+}
+
+// Unwrap 返回被包装的错误，支持errors.Is和errors.As
+func (err *StackErr) Unwrap() error {
+	return err.cause
+}
+
+// Stack 返回创建错误时的调用栈，第一次调用时才解析
+func (err *StackErr) Stack() string {
+	err.stackOnce.Do(func() {
+		b := strings.Builder{}
+		for _, frame := range err.StackTrace() {
+			b.WriteString(frame.Function)
+			b.WriteString("()\n\t")
+			b.WriteString(frame.File)
+			b.WriteString(":")
+			b.WriteString(strconv.Itoa(frame.Line))
+			b.WriteString("\n")
+		}
+		err.stack = b.String()
+	})
+
+	return err.stack
+}
+
+// StackTrace 返回创建错误时的调用栈
+func (err *StackErr) StackTrace() []Frame {
+	return framesOf(err.pcs)
+}
+
+/*
+Format 实现fmt.Formatter
+
+	%s %v  错误信息
+	%q     带引号的错误信息
+	%+v    错误信息和调用栈，被包装的StackErr的调用栈也会输出
+*/
+func (err *StackErr) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			_, _ = io.WriteString(s, err.Error())
+			err.writeStacks(s)
+			return
+		}
+		_, _ = io.WriteString(s, err.Error())
+	case 's':
+		_, _ = io.WriteString(s, err.Error())
+	case 'q':
+		_, _ = fmt.Fprintf(s, "%q", err.Error())
+	}
+}
+
+// writeStacks 从外到内输出整条错误链上的调用栈
+func (err *StackErr) writeStacks(w io.Writer) {
+	var cause error = err
+	for cause != nil {
+		if stackErr, ok := cause.(*StackErr); ok && len(stackErr.pcs) > 0 {
+			_, _ = io.WriteString(w, "\n")
+			_, _ = io.WriteString(w, strings.TrimSuffix(stackErr.Stack(), "\n"))
+		}
+		cause = errors.Unwrap(cause)
+	}
 }
@@ -1,8 +1,181 @@
 package stackerr
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
 
 func TestStackErr_Error(t *testing.T) {
 	err := New()
 	t.Log(err.Error())
 }
+
+func TestWrap(t *testing.T) {
+	if Wrap(nil, "nil") != nil {
+		t.Fatal("Wrap(nil) should be nil")
+	}
+
+	err := Wrap(io.EOF, "read config")
+	if err.Error() != "read config: EOF" {
+		t.Errorf("got %q", err.Error())
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Error("errors.Is should find io.EOF")
+	}
+	if Cause(Wrapf(err, "load %s", "app")) != io.EOF {
+		t.Error("Cause should return io.EOF")
+	}
+
+	var stackErr *StackErr
+	if !errors.As(fmt.Errorf("outer: %w", err), &stackErr) {
+		t.Error("errors.As should find *StackErr")
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	err := Errorf("open %s: %w", "a.txt", io.ErrUnexpectedEOF)
+	if err.Error() != "open a.txt: unexpected EOF" {
+		t.Errorf("got %q", err.Error())
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("errors.Is should find io.ErrUnexpectedEOF")
+	}
+
+	err = Errorf("code %d", 3)
+	if err.Error() != "code 3" || Unwrap(err) != nil {
+		t.Errorf("got %q", err.Error())
+	}
+}
+
+func TestStackErr_Format(t *testing.T) {
+	err := Wrap(New("inner"), "outer")
+
+	if s := fmt.Sprintf("%v", err); s != "outer: inner" {
+		t.Errorf("%%v: got %q", s)
+	}
+
+	s := fmt.Sprintf("%+v", err)
+	if !strings.HasPrefix(s, "outer: inner\n") || strings.Count(s, "TestStackErr_Format") != 2 {
+		t.Errorf("%%+v: got %q", s)
+	}
+}
+
+func TestStackErr_StackTrace(t *testing.T) {
+	SetMaxDepth(1)
+	defer SetMaxDepth(0)
+
+	frames := New("depth").(*StackErr).StackTrace()
+	if len(frames) != 1 || !strings.HasSuffix(frames[0].Function, "TestStackErr_StackTrace") {
+		t.Errorf("got %+v", frames)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = New("validate")
+	}
+}
+
+func TestWith(t *testing.T) {
+	err := With(Wrap(io.EOF, "read"), "user_id", 1)
+	err = With(Wrap(err, "handle"), "path", "/a", "dangling")
+
+	fields := Fields(err)
+	if len(fields) != 3 || fields[0].Key != "user_id" || fields[1].Key != "path" || fields[2].Key != "!BADKEY" {
+		t.Fatalf("got %+v", fields)
+	}
+	if err.Error() != "handle: read: EOF" {
+		t.Errorf("got %q", err.Error())
+	}
+
+	b, jsonErr := json.Marshal(err)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	var j map[string]interface{}
+	_ = json.Unmarshal(b, &j)
+	if j["fields"].(map[string]interface{})["path"] != "/a" || j["cause"] == nil {
+		t.Errorf("got %s", b)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", err)
+	if !strings.Contains(buf.String(), `"fields":{"user_id":1,"path":"/a"`) {
+		t.Errorf("got %s", buf.String())
+	}
+}
+
+func TestStackErr_MarshalJSON_WrappedByFmt(t *testing.T) {
+	inner := With(io.EOF, "user_id", 1)
+	err := Wrap(fmt.Errorf("middle: %w", inner), "outer")
+
+	b, jsonErr := json.Marshal(err)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+
+	var j struct {
+		Cause struct {
+			Message string
+			Cause   struct {
+				Fields map[string]interface{}
+				Frames []jsonFrame
+			}
+		}
+	}
+	_ = json.Unmarshal(b, &j)
+	if j.Cause.Message != "middle: EOF" || j.Cause.Cause.Fields["user_id"] != float64(1) || len(j.Cause.Cause.Frames) == 0 {
+		t.Errorf("got %s", b)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Error("failed", "err", err)
+	if !strings.Contains(buf.String(), `"fields":{"user_id":1}`) || !strings.Contains(buf.String(), `"frames":[`) {
+		t.Errorf("got %s", buf.String())
+	}
+}
+
+func newSiteErr() error {
+	return New("site")
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator(WithMetricsNamespace("test"), WithMaxSites(2))
+
+	for i := 0; i < 3; i++ {
+		a.Observe(Wrap(newSiteErr(), "wrapped"))
+	}
+	a.Observe(New("another"))
+	a.Observe(New("overflow"))
+	if a.Observe(io.EOF) != "" {
+		t.Error("errors without stack should not be counted")
+	}
+
+	sites := a.Sites()
+	if len(sites) != 3 {
+		t.Fatalf("got %d sites", len(sites))
+	}
+	for _, site := range sites {
+		if strings.HasSuffix(site.Function, "newSiteErr") && site.Count != 3 {
+			t.Errorf("newSiteErr: got %d, want 3", site.Count)
+		}
+	}
+
+	if Fingerprint(newSiteErr(), 0) != Fingerprint(Wrap(newSiteErr(), "x"), 0) {
+		t.Error("fingerprint should only depend on the origin")
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(a)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+package stat
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	defaultNamespace = "dprof"
+)
+
+var _ prometheus.Collector = (*Stat)(nil)
+
+// descs 每个Stat实例自己的指标描述
+type descs struct {
+	processCpuUsage             *prometheus.Desc
+	processCpuUsageStdDeviation *prometheus.Desc
+	processMemUsage             *prometheus.Desc
+	processOpenFds              *prometheus.Desc
+	processMaxFds               *prometheus.Desc
+	processThreads              *prometheus.Desc
+	processCtxSwitches          *prometheus.Desc
+	processIoBytes              *prometheus.Desc
+	processFdLeakSuspected      *prometheus.Desc
+	tcpSockets                  *prometheus.Desc
+	tcpCloseWaitLeakSuspected   *prometheus.Desc
+	sockstatTcp                 *prometheus.Desc
+	runtimeCpus                 *prometheus.Desc
+	runtimeGoroutines           *prometheus.Desc
+	goroutineLeakSuspected      *prometheus.Desc
+	goroutineLeakGroup          *prometheus.Desc
+	hostCpu                     *prometheus.Desc
+	cgroupCpuQuota              *prometheus.Desc
+	cgroupCpuPeriod             *prometheus.Desc
+	cgroupCpusetCpus            *prometheus.Desc
+	cgroupCpuPeriods            *prometheus.Desc
+	cgroupCpuThrottledPeriods   *prometheus.Desc
+	cgroupCpuThrottledSeconds   *prometheus.Desc
+	cgroupCpuThrottledRatio     *prometheus.Desc
+	pressureAvg                 *prometheus.Desc
+	pressureStalled             *prometheus.Desc
+
+	legacy *legacyDescs
+}
+
+func newDescs(namespace string, constLabels prometheus.Labels) *descs {
+	newDesc := func(subsystem, name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, constLabels)
+	}
+
+	return &descs{
+		processCpuUsage:             newDesc("process", "cpu_usage_ratio", "进程cpu使用率 0~1", nil),
+		processCpuUsageStdDeviation: newDesc("process", "cpu_usage_stddev_ratio", "最近进程cpu使用率的标准差 0~1", nil),
+		processMemUsage:             newDesc("process", "memory_usage_ratio", "进程内存使用率 0~1", nil),
+		processOpenFds:              newDesc("process", "open_fds", "进程打开的fd数", nil),
+		processMaxFds:               newDesc("process", "max_fds", "进程可以打开的最大fd数 RLIMIT_NOFILE", nil),
+		processThreads:              newDesc("process", "threads", "进程的系统线程数", nil),
+		processCtxSwitches:          newDesc("process", "context_switches_total", "进程的上下文切换次数，voluntary为主动让出cpu", []string{"type"}),
+		processIoBytes:              newDesc("process", "io_bytes_total", "进程读写的字节数，storage为存储设备，syscall为系统调用", []string{"direction", "source"}),
+		processFdLeakSuspected:      newDesc("process", "fd_leak_suspected", "是否疑似fd泄漏 1为是", nil),
+		tcpSockets:                  newDesc("tcp", "sockets", "进程持有的tcp连接数，port为监听端口，主动发起的连接为client，不包括TIME_WAIT(见sockstat_tcp的tw)", []string{"state", "port"}),
+		tcpCloseWaitLeakSuspected:   newDesc("tcp", "close_wait_leak_suspected", "CLOSE_WAIT是否持续增长 1为是", nil),
+		sockstatTcp:                 newDesc("sockstat", "tcp", "网络命名空间范围的tcp统计，来自/proc/net/sockstat", []string{"type"}),
+		runtimeCpus:                 newDesc("runtime", "cpus", "可用逻辑cpu核心数", nil),
+		runtimeGoroutines:           newDesc("runtime", "goroutines", "当前协程数", nil),
+		goroutineLeakSuspected:      newDesc("goroutine_leak", "suspected", "是否疑似协程泄漏 1为是", nil),
+		goroutineLeakGroup:          newDesc("goroutine_leak", "group_goroutines", "疑似泄漏时增长最快的协程分组的数量", []string{"created_by"}),
+		hostCpu:                     newDesc("host", "cpu_ratio", "主机cpu各状态的时间比例 0~1，cpu为cpu时是所有核心合计", []string{"cpu", "mode"}),
+		cgroupCpuQuota:              newDesc("cgroup", "cpu_quota_cores", "cgroup的cpu配额换算成的核心数，0为不限制", nil),
+		cgroupCpuPeriod:             newDesc("cgroup", "cpu_period_seconds", "cgroup的cpu时间周期", nil),
+		cgroupCpusetCpus:            newDesc("cgroup", "cpuset_cpus", "cgroup可以使用的cpu核心数", nil),
+		cgroupCpuPeriods:            newDesc("cgroup", "cpu_periods_total", "cgroup经过的cpu时间周期数", nil),
+		cgroupCpuThrottledPeriods:   newDesc("cgroup", "cpu_throttled_periods_total", "cgroup被限流的cpu时间周期数", nil),
+		cgroupCpuThrottledSeconds:   newDesc("cgroup", "cpu_throttled_seconds_total", "cgroup被限流的总时间", nil),
+		cgroupCpuThrottledRatio:     newDesc("cgroup", "cpu_throttled_ratio", "最近一次采样间隔内被限流的时间周期比例 0~1", nil),
+		pressureAvg:                 newDesc("pressure", "avg_ratio", "PSI 资源不足导致任务等待的时间比例 0~1", []string{"scope", "resource", "kind", "window"}),
+		pressureStalled:             newDesc("pressure", "stalled_seconds_total", "PSI 资源不足导致任务等待的累计时间", []string{"scope", "resource", "kind"}),
+
+		legacy: newLegacyDescs(constLabels),
+	}
+}
+
+func (stat *Stat) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stat.descs.processCpuUsage
+	ch <- stat.descs.processCpuUsageStdDeviation
+	ch <- stat.descs.processMemUsage
+	ch <- stat.descs.processOpenFds
+	ch <- stat.descs.processMaxFds
+	ch <- stat.descs.processThreads
+	ch <- stat.descs.processCtxSwitches
+	ch <- stat.descs.processIoBytes
+	ch <- stat.descs.processFdLeakSuspected
+	ch <- stat.descs.tcpSockets
+	ch <- stat.descs.tcpCloseWaitLeakSuspected
+	ch <- stat.descs.sockstatTcp
+	ch <- stat.descs.runtimeCpus
+	ch <- stat.descs.runtimeGoroutines
+	ch <- stat.descs.goroutineLeakSuspected
+	ch <- stat.descs.goroutineLeakGroup
+	ch <- stat.descs.hostCpu
+	ch <- stat.descs.cgroupCpuQuota
+	ch <- stat.descs.cgroupCpuPeriod
+	ch <- stat.descs.cgroupCpusetCpus
+	ch <- stat.descs.cgroupCpuPeriods
+	ch <- stat.descs.cgroupCpuThrottledPeriods
+	ch <- stat.descs.cgroupCpuThrottledSeconds
+	ch <- stat.descs.cgroupCpuThrottledRatio
+	ch <- stat.descs.pressureAvg
+	ch <- stat.descs.pressureStalled
+
+	stat.runtimeSampler.Describe(ch)
+
+	if stat.legacyMetrics {
+		stat.descs.legacy.describe(ch)
+	}
+}
+
+// Collect 抓取时从最近一次采样的结果生成指标
+func (stat *Stat) Collect(ch chan<- prometheus.Metric) {
+	stat.mu.RLock()
+	m := stat.Metrics
+	stat.mu.RUnlock()
+
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+	}
+
+	gauge(stat.descs.processCpuUsage, float64(m.CpuUsage)/1000)
+	gauge(stat.descs.processCpuUsageStdDeviation, m.CpuUsageStdDeviation/1000)
+	gauge(stat.descs.processMemUsage, float64(m.MemUsage)/1000)
+
+	// 只有linux下采集
+	if m.Process.Available {
+		gauge(stat.descs.processOpenFds, float64(m.Process.OpenFds))
+		gauge(stat.descs.processMaxFds, float64(m.Process.MaxFds))
+		gauge(stat.descs.processThreads, float64(m.Process.Threads))
+		counter(stat.descs.processCtxSwitches, float64(m.Process.VoluntaryCtxSwitches), "voluntary")
+		counter(stat.descs.processCtxSwitches, float64(m.Process.InvoluntaryCtxSwitches), "involuntary")
+		counter(stat.descs.processIoBytes, float64(m.Process.ReadBytes), "read", "storage")
+		counter(stat.descs.processIoBytes, float64(m.Process.WriteBytes), "write", "storage")
+		counter(stat.descs.processIoBytes, float64(m.Process.ReadChars), "read", "syscall")
+		counter(stat.descs.processIoBytes, float64(m.Process.WriteChars), "write", "syscall")
+		if m.Process.FdLeakSuspected {
+			gauge(stat.descs.processFdLeakSuspected, 1)
+		} else {
+			gauge(stat.descs.processFdLeakSuspected, 0)
+		}
+	}
+
+	if m.Tcp.Available {
+		for key, count := range m.Tcp.Sockets {
+			gauge(stat.descs.tcpSockets, float64(count), key.State, key.Port)
+		}
+		if m.Tcp.CloseWaitLeakSuspected {
+			gauge(stat.descs.tcpCloseWaitLeakSuspected, 1)
+		} else {
+			gauge(stat.descs.tcpCloseWaitLeakSuspected, 0)
+		}
+	}
+	if m.Tcp.Sockstat.Available {
+		gauge(stat.descs.sockstatTcp, float64(m.Tcp.Sockstat.InUse), "inuse")
+		gauge(stat.descs.sockstatTcp, float64(m.Tcp.Sockstat.Orphan), "orphan")
+		gauge(stat.descs.sockstatTcp, float64(m.Tcp.Sockstat.TimeWait), "tw")
+		gauge(stat.descs.sockstatTcp, float64(m.Tcp.Sockstat.Alloc), "alloc")
+		gauge(stat.descs.sockstatTcp, float64(m.Tcp.Sockstat.MemPages), "mem")
+	}
+
+	gauge(stat.descs.runtimeCpus, float64(m.CpuNum))
+	gauge(stat.descs.runtimeGoroutines, float64(m.GoroutineNum))
+
+	if m.GoroutineLeakSuspected {
+		gauge(stat.descs.goroutineLeakSuspected, 1)
+	} else {
+		gauge(stat.descs.goroutineLeakSuspected, 0)
+	}
+	for _, group := range m.GoroutineLeakGroups {
+		gauge(stat.descs.goroutineLeakGroup, float64(group.Count), group.CreatedBy)
+	}
+
+	// 还没有采样过/proc/stat时不输出
+	if m.HostCpu.Cpu != "" {
+		for _, modes := range append([]CpuModes{m.HostCpu}, m.HostCpuCores...) {
+			gauge(stat.descs.hostCpu, modes.User, modes.Cpu, "user")
+			gauge(stat.descs.hostCpu, modes.System, modes.Cpu, "system")
+			gauge(stat.descs.hostCpu, modes.Idle, modes.Cpu, "idle")
+			gauge(stat.descs.hostCpu, modes.Iowait, modes.Cpu, "iowait")
+			gauge(stat.descs.hostCpu, modes.Irq, modes.Cpu, "irq")
+			gauge(stat.descs.hostCpu, modes.Softirq, modes.Cpu, "softirq")
+			gauge(stat.descs.hostCpu, modes.Steal, modes.Cpu, "steal")
+		}
+	}
+
+	// 不在cgroup中时不输出；读不到配额(比如cpu.max读取失败)时限流的计数依然输出
+	if m.CgroupCpu.PeriodSeconds > 0 {
+		gauge(stat.descs.cgroupCpuQuota, m.CgroupCpu.QuotaCores)
+		gauge(stat.descs.cgroupCpuPeriod, m.CgroupCpu.PeriodSeconds)
+	}
+	if m.CgroupCpu.CpusetCpus > 0 {
+		gauge(stat.descs.cgroupCpusetCpus, float64(m.CgroupCpu.CpusetCpus))
+	}
+	if m.CgroupCpu.Available {
+		counter(stat.descs.cgroupCpuPeriods, float64(m.CgroupCpu.Periods))
+		counter(stat.descs.cgroupCpuThrottledPeriods, float64(m.CgroupCpu.ThrottledPeriods))
+		counter(stat.descs.cgroupCpuThrottledSeconds, m.CgroupCpu.ThrottledSeconds)
+		gauge(stat.descs.cgroupCpuThrottledRatio, m.CgroupCpu.ThrottledRatio)
+	}
+
+	stat.collectPressure(ch, "host", m.HostPressure)
+	stat.collectPressure(ch, "cgroup", m.CgroupPressure)
+
+	stat.runtimeSampler.Collect(ch)
+
+	if stat.legacyMetrics {
+		stat.descs.legacy.collect(ch, &m)
+	}
+}
+
+// collectPressure 没有采集到时不输出
+func (stat *Stat) collectPressure(ch chan<- prometheus.Metric, scope string, pressure Pressure) {
+	if !pressure.Available {
+		return
+	}
+
+	collect := func(resource, kind string, line PressureLine) {
+		ch <- prometheus.MustNewConstMetric(stat.descs.pressureAvg, prometheus.GaugeValue, line.Avg10/100, scope, resource, kind, "10s")
+		ch <- prometheus.MustNewConstMetric(stat.descs.pressureAvg, prometheus.GaugeValue, line.Avg60/100, scope, resource, kind, "60s")
+		ch <- prometheus.MustNewConstMetric(stat.descs.pressureAvg, prometheus.GaugeValue, line.Avg300/100, scope, resource, kind, "300s")
+		ch <- prometheus.MustNewConstMetric(stat.descs.pressureStalled, prometheus.CounterValue, float64(line.Total)/1e6, scope, resource, kind)
+	}
+
+	collect("cpu", "some", pressure.Cpu.Some)
+	collect("cpu", "full", pressure.Cpu.Full)
+	collect("memory", "some", pressure.Memory.Some)
+	collect("memory", "full", pressure.Memory.Full)
+	collect("io", "some", pressure.Io.Some)
+	collect("io", "full", pressure.Io.Full)
+}
@@ -0,0 +1,184 @@
+package stat
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	goroutineLeakWindow         = time.Minute      // 观察窗口
+	goroutineLeakMinSlope       = 1.0              // 窗口内最小增长斜率，单位 个/秒，按采集间隔换算成 个/采样
+	goroutineLeakMonotonicRatio = 0.9              // 窗口内不下降的采样占比
+	goroutineLeakTopN           = 10               // 上报增长最快的分组数
+	goroutineLeakCaptureCD      = 60 * time.Second // 两次抓取协程剖析的最小间隔
+)
+
+// GoroutineGroup 按创建位置归类的协程
+type GoroutineGroup struct {
+	CreatedBy string // 创建位置，格式为 函数 文件:行号
+	Count     int    // 当前数量
+	Growth    int    // 相对上一次抓取的增长
+}
+
+// GoroutineLeakDetector 协程泄漏检测器
+type GoroutineLeakDetector struct {
+	logger      *slog.Logger
+	trend       *TrendDetector
+	interval    time.Duration
+	prevGroups  map[string]int
+	lastCapture time.Time
+	now         func() time.Time // 时钟，测试时替换
+	capture     func()           // 抓取协程剖析，测试时替换
+
+	Suspected bool             // 是否疑似泄漏
+	Slope     float64          // 窗口内的增长斜率，单位 个/秒
+	TopGroups []GoroutineGroup // 增长最快的分组
+}
+
+// NewGoroutineLeakDetector interval为协程数的采集间隔，窗口长度和斜率阈值都按它换算
+func NewGoroutineLeakDetector(logger *slog.Logger, interval time.Duration) *GoroutineLeakDetector {
+	if interval <= 0 {
+		interval = defaultRuntimeInterval
+	}
+
+	window := int(goroutineLeakWindow / interval)
+	if window < 2 {
+		window = 2
+	}
+
+	detector := &GoroutineLeakDetector{
+		logger:   logger,
+		trend:    NewTrendDetector(window, goroutineLeakMinSlope*interval.Seconds(), goroutineLeakMonotonicRatio),
+		interval: interval,
+		now:      time.Now,
+	}
+	detector.capture = detector.captureGroups
+
+	return detector
+}
+
+// Add 放入一次协程数采样，返回是否疑似泄漏
+func (detector *GoroutineLeakDetector) Add(num int) bool {
+	increasing := detector.trend.Add(num)
+
+	detector.Suspected = false
+	detector.Slope = detector.trend.Slope / detector.interval.Seconds()
+	if !increasing {
+		return false
+	}
+
+	detector.Suspected = true
+	if now := detector.now(); now.Sub(detector.lastCapture) >= goroutineLeakCaptureCD {
+		detector.lastCapture = now
+		detector.capture()
+	}
+
+	return true
+}
+
+// captureGroups 抓取协程剖析，按创建位置归类并找出增长最快的分组
+func (detector *GoroutineLeakDetector) captureGroups() {
+	var buf bytes.Buffer
+	err := pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	if err != nil {
+		detector.logger.Error("write goroutine profile failed", "err", err)
+		return
+	}
+
+	groups := groupGoroutines(buf.Bytes())
+
+	var list []GoroutineGroup
+	for createdBy, count := range groups {
+		list = append(list, GoroutineGroup{
+			CreatedBy: createdBy,
+			Count:     count,
+			Growth:    count - detector.prevGroups[createdBy],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Growth != list[j].Growth {
+			return list[i].Growth > list[j].Growth
+		}
+		return list[i].Count > list[j].Count
+	})
+	if len(list) > goroutineLeakTopN {
+		list = list[:goroutineLeakTopN]
+	}
+
+	detector.prevGroups = groups
+	detector.TopGroups = list
+
+	for _, group := range list {
+		detector.logger.Warn("goroutine leak suspected",
+			"slope", detector.Slope,
+			"created_by", group.CreatedBy,
+			"count", group.Count,
+			"growth", group.Growth,
+		)
+	}
+}
+
+/*
+groupGoroutines 解析 debug=2 格式的协程剖析，按创建位置统计数量
+
+	goroutine 7 [sleep]:
+	time.Sleep(0x34630b8a000)
+		/usr/local/go/src/runtime/time.go:368 +0x165
+	main.main.func1()
+		/tmp/g.go:3 +0x1d
+	created by main.main in goroutine 1
+		/tmp/g.go:3 +0x25
+*/
+func groupGoroutines(profile []byte) map[string]int {
+	groups := make(map[string]int)
+
+	scanner := bufio.NewScanner(bytes.NewReader(profile))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	inGoroutine := false
+	createdBy := ""
+	flush := func() {
+		if !inGoroutine {
+			return
+		}
+		if createdBy == "" {
+			// 没有创建者的只有主协程
+			createdBy = "main"
+		}
+		groups[createdBy]++
+		inGoroutine = false
+		createdBy = ""
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			flush()
+			inGoroutine = true
+		case strings.HasPrefix(line, "created by "):
+			fn := strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(fn, " in goroutine "); i >= 0 {
+				fn = fn[:i]
+			}
+			createdBy = fn
+
+			// 下一行是创建位置
+			if scanner.Scan() {
+				pos := strings.TrimSpace(scanner.Text())
+				if i := strings.LastIndex(pos, " +0x"); i >= 0 {
+					pos = pos[:i]
+				}
+				createdBy += " " + pos
+			}
+		}
+	}
+	flush()
+
+	return groups
+}
@@ -0,0 +1,70 @@
+package stat
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestGroupGoroutines(t *testing.T) {
+	profile := []byte(`goroutine 1 [running]:
+main.main()
+	/tmp/g.go:3 +0x66
+
+goroutine 7 [sleep]:
+time.Sleep(0x34630b8a000)
+	/usr/local/go/src/runtime/time.go:368 +0x165
+created by main.main in goroutine 1
+	/tmp/g.go:3 +0x25
+
+goroutine 8 [sleep]:
+time.Sleep(0x34630b8a000)
+	/usr/local/go/src/runtime/time.go:368 +0x165
+created by main.main in goroutine 1
+	/tmp/g.go:3 +0x25
+`)
+
+	groups := groupGoroutines(profile)
+	if groups["main"] != 1 {
+		t.Errorf("main: got %d, want 1", groups["main"])
+	}
+	if groups["main.main /tmp/g.go:3"] != 2 {
+		t.Errorf("main.main: got %d, want 2", groups["main.main /tmp/g.go:3"])
+	}
+}
+
+func TestGoroutineLeakDetector_Add(t *testing.T) {
+	for _, interval := range []time.Duration{time.Second, 250 * time.Millisecond} {
+		detector := NewGoroutineLeakDetector(slog.Default(), interval)
+		now := time.Unix(0, 0)
+		detector.now = func() time.Time { return now }
+		captures := 0
+		detector.capture = func() { captures++ }
+
+		samples := int(goroutineLeakWindow / interval)
+		for i := 0; i < samples; i++ {
+			detector.Add(100)
+			now = now.Add(interval)
+		}
+		if detector.Suspected {
+			t.Fatalf("%v: flat samples should not be suspected", interval)
+		}
+
+		// 每秒增长2个，和采集间隔无关
+		for i := 0; i < samples; i++ {
+			detector.Add(100 + int(2*float64(i)*interval.Seconds()))
+			now = now.Add(interval)
+		}
+		if !detector.Suspected {
+			t.Fatalf("%v: growing samples should be suspected, slope: %v", interval, detector.Slope)
+		}
+		if detector.Slope < 1.9 || detector.Slope > 2.1 {
+			t.Errorf("%v: slope: got %v, want 2/s", interval, detector.Slope)
+		}
+
+		// 冷却时间内只抓取一次
+		if captures != 1 {
+			t.Errorf("%v: captures: got %d, want 1", interval, captures)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package stat
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// legacyDescs 旧的指标名，单位不统一，开启WithLegacyMetrics时才会输出，过渡期后删除
+type legacyDescs struct {
+	processCpuUsage            *prometheus.Desc
+	processRecentCpuUsageLevel *prometheus.Desc
+	processMemUsage            *prometheus.Desc
+	processRecentMemUsage      *prometheus.Desc
+	runtimeMemInfo             *prometheus.Desc
+}
+
+func newLegacyDescs(constLabels prometheus.Labels) *legacyDescs {
+	return &legacyDescs{
+		processCpuUsage:            prometheus.NewDesc("process_cpu_usage", "进程cpu使用率 比例为(1/1000)，已废弃，使用dprof_process_cpu_usage_ratio", nil, constLabels),
+		processRecentCpuUsageLevel: prometheus.NewDesc("process_recent_cpu_usageX", "最近进程cpu使用率 比例为(1/1000)，已废弃，使用dprof_process_cpu_usage_ratio", []string{"cpu"}, constLabels),
+		processMemUsage:            prometheus.NewDesc("process_mem_usage", "进程内存使用率 比例为(1/1000)，已废弃，使用dprof_process_memory_usage_ratio", nil, constLabels),
+		processRecentMemUsage:      prometheus.NewDesc("process_recent_mem_usage", "最近进程内存使用率 比例为(1/1000)，已废弃，使用dprof_process_memory_usage_ratio", []string{"mem"}, constLabels),
+		runtimeMemInfo:             prometheus.NewDesc("runtime_mem_info", "运行时内存信息，已废弃，使用dprof_runtime_*", []string{"runtime_mem"}, constLabels),
+	}
+}
+
+func (descs *legacyDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- descs.processCpuUsage
+	ch <- descs.processRecentCpuUsageLevel
+	ch <- descs.processMemUsage
+	ch <- descs.processRecentMemUsage
+	ch <- descs.runtimeMemInfo
+}
+
+func (descs *legacyDescs) collect(ch chan<- prometheus.Metric, m *Metrics) {
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+
+	gauge(descs.processCpuUsage, float64(m.CpuUsage))
+	gauge(descs.processRecentCpuUsageLevel, float64(m.CpuUsage), "0ms")
+	gauge(descs.processRecentCpuUsageLevel, float64(m.PrevCpuUsage1), "250ms")
+	gauge(descs.processRecentCpuUsageLevel, float64(m.PrevCpuUsage2), "500ms")
+	gauge(descs.processRecentCpuUsageLevel, m.CpuUsageStdDeviation, "std deviation")
+
+	gauge(descs.processMemUsage, float64(m.MemUsage))
+	gauge(descs.processRecentMemUsage, float64(m.MemUsage), "0ms")
+	gauge(descs.processRecentMemUsage, float64(m.PrevMemUsage1), "250ms")
+	gauge(descs.processRecentMemUsage, float64(m.PrevMemUsage2), "500ms")
+
+	gauge(descs.runtimeMemInfo, float64(m.CpuNum), "CpuNum")
+	gauge(descs.runtimeMemInfo, float64(m.GoroutineNum), "Goroutines")
+	gauge(descs.runtimeMemInfo, float64(m.TotalAlloc)/(1024*1024), "TotalAlloc")
+	gauge(descs.runtimeMemInfo, float64(m.Alloc)/(1024*1024), "Alloc")
+	gauge(descs.runtimeMemInfo, float64(m.Sys)/(1024*1024), "Sys")
+	gauge(descs.runtimeMemInfo, float64(m.NumGC), "NumGC")
+	gauge(descs.runtimeMemInfo, float64(m.HeapInuse)/(1024*1024), "HeapInuse")
+	gauge(descs.runtimeMemInfo, float64(m.HeapAlloc)/(1024*1024), "HeapAlloc")
+	gauge(descs.runtimeMemInfo, float64(m.HeapIdle)/(1024*1024), "HeapIdle")
+	gauge(descs.runtimeMemInfo, float64(m.HeapReleased)/(1024*1024), "HeapReleased")
+}
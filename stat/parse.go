@@ -0,0 +1,36 @@
+package stat
+
+// NextField 跳过空白，返回下一列和剩下的内容，用于不分配内存地解析/proc和cgroup的文件
+func NextField(data []byte) ([]byte, []byte) {
+	start := 0
+	for start < len(data) && isSpace(data[start]) {
+		start++
+	}
+	end := start
+	for end < len(data) && !isSpace(data[end]) {
+		end++
+	}
+
+	return data[start:end], data[end:]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// ParseUint 不分配内存的无符号整数解析
+func ParseUint(b []byte) (uint64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var v uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + uint64(c-'0')
+	}
+
+	return v, true
+}
@@ -0,0 +1,28 @@
+package stat
+
+import (
+	"testing"
+)
+
+func TestNextField(t *testing.T) {
+	data := []byte("1234 (my app) S 1 1234")
+	field, rest := NextField(data)
+	if string(field) != "1234" {
+		t.Errorf("got %q", field)
+	}
+	field, _ = NextField(rest)
+	if string(field) != "(my" {
+		t.Errorf("got %q", field)
+	}
+
+	// /proc/self/status和meminfo用制表符分隔
+	field, rest = NextField([]byte("Threads:\t12\n"))
+	field, _ = NextField(rest)
+	if string(field) != "12" {
+		t.Errorf("got %q", field)
+	}
+
+	if _, ok := ParseUint([]byte("12a")); ok {
+		t.Error("12a should not parse")
+	}
+}
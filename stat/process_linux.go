@@ -0,0 +1,150 @@
+package stat
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// USER_HZ，/proc/self/stat中的时间单位。内核导出给用户态的时间单位与编译时的CONFIG_HZ无关，
+	// 除了alpha以外的架构都固定为100，go支持的架构都是100；读取sysconf(_SC_CLK_TCK)需要cgo
+	clockTicks = 100
+)
+
+var (
+	ErrorBadProcStat = errors.New("bad /proc/self/stat")
+)
+
+// processSampler 直接读取/proc/self/stat和statm，文件一直打开，每次从头读到复用的缓冲区，采样时不分配内存
+type processSampler struct {
+	statFile  *os.File
+	statmFile *os.File
+	buf       [1024]byte
+
+	pageSize uint64
+	memTotal uint64 // 主机的物理内存，单位字节
+
+	prevTicks uint64
+	prevTime  time.Time
+}
+
+func newProcessSampler() (*processSampler, error) {
+	statFile, err := os.Open("/proc/self/stat")
+	if err != nil {
+		return nil, err
+	}
+
+	statmFile, err := os.Open("/proc/self/statm")
+	if err != nil {
+		_ = statFile.Close()
+		return nil, err
+	}
+
+	memTotal, err := readMemTotal()
+	if err != nil {
+		_ = statFile.Close()
+		_ = statmFile.Close()
+		return nil, err
+	}
+
+	return &processSampler{
+		statFile:  statFile,
+		statmFile: statmFile,
+		pageSize:  uint64(os.Getpagesize()),
+		memTotal:  memTotal,
+	}, nil
+}
+
+// Percent 进程cpu时间占一个核心的百分比，与gopsutil的Percent(0)一致，第一次调用返回0
+func (p *processSampler) Percent() (float64, error) {
+	data, err := p.read(p.statFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// comm可能包含空格和括号，从最后一个)之后开始数，utime和stime是第14、15列
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, ErrorBadProcStat
+	}
+	fields := data[i+1:]
+
+	var utime, stime uint64
+	var ok bool
+	for n := 3; n <= 15; n++ {
+		var field []byte
+		field, fields = NextField(fields)
+		switch n {
+		case 14:
+			utime, ok = ParseUint(field)
+		case 15:
+			stime, ok = ParseUint(field)
+		}
+		if (n == 14 || n == 15) && !ok {
+			return 0, ErrorBadProcStat
+		}
+	}
+
+	now := time.Now()
+	ticks := utime + stime
+	prevTicks, prevTime := p.prevTicks, p.prevTime
+	p.prevTicks, p.prevTime = ticks, now
+	if prevTime.IsZero() || ticks < prevTicks {
+		return 0, nil
+	}
+
+	cpuSeconds := float64(ticks-prevTicks) / clockTicks
+	return cpuSeconds / now.Sub(prevTime).Seconds() * 100, nil
+}
+
+// MemoryPercent 常驻内存占主机物理内存的百分比，与gopsutil的MemoryPercent一致，在cgroup中也不按内存限制计算
+func (p *processSampler) MemoryPercent() (float32, error) {
+	data, err := p.read(p.statmFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// size resident shared text lib data dt，单位页
+	_, data = NextField(data)
+	field, _ := NextField(data)
+	resident, ok := ParseUint(field)
+	if !ok {
+		return 0, ErrorBadProcStat
+	}
+
+	return float32(float64(resident*p.pageSize) / float64(p.memTotal) * 100), nil
+}
+
+// read 从头读取文件，/proc的文件每次从偏移0读都会重新生成内容
+func (p *processSampler) read(f *os.File) ([]byte, error) {
+	n, err := f.ReadAt(p.buf[:], 0)
+	if n == 0 && err != nil {
+		return nil, err
+	}
+
+	return p.buf[:n], nil
+}
+
+// readMemTotal 读取/proc/meminfo中的MemTotal，只在初始化时读取一次
+func readMemTotal() (uint64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, ok := ParseUint([]byte(fields[1]))
+			if !ok {
+				break
+			}
+			return kb * 1024, nil
+		}
+	}
+
+	return 0, errors.New("no MemTotal in /proc/meminfo")
+}
@@ -0,0 +1,31 @@
+package stat
+
+import (
+	"testing"
+)
+
+func TestProcessSampler_NoAllocs(t *testing.T) {
+	p, err := newProcessSampler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Percent(); err != nil {
+		t.Fatal(err)
+	}
+	mem, err := p.MemoryPercent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem <= 0 || mem > 100 {
+		t.Errorf("got memory percent %v", mem)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = p.Percent()
+		_, _ = p.MemoryPercent()
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs per sample, want 0", allocs)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package stat
+
+import (
+	"github.com/shirou/gopsutil/process"
+	"os"
+)
+
+// processSampler 非linux使用gopsutil
+type processSampler struct {
+	*process.Process
+}
+
+func newProcessSampler() (*processSampler, error) {
+	p, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &processSampler{Process: p}, nil
+}
+
+// Percent 进程cpu时间占一个核心的百分比，第一次调用返回0
+func (p *processSampler) Percent() (float64, error) {
+	return p.Process.Percent(0)
+}
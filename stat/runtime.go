@@ -0,0 +1,369 @@
+package stat
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	runtimeWindow = 10 * time.Second // 窗口统计的时长
+)
+
+const (
+	metricHeapObjects      = "/memory/classes/heap/objects:bytes"
+	metricHeapUnused       = "/memory/classes/heap/unused:bytes"
+	metricHeapFree         = "/memory/classes/heap/free:bytes"
+	metricHeapReleased     = "/memory/classes/heap/released:bytes"
+	metricHeapStacks       = "/memory/classes/heap/stacks:bytes"
+	metricOSStacks         = "/memory/classes/os-stacks:bytes"
+	metricMetadataOther    = "/memory/classes/metadata/other:bytes"
+	metricMCacheInuse      = "/memory/classes/metadata/mcache/inuse:bytes"
+	metricMSpanInuse       = "/memory/classes/metadata/mspan/inuse:bytes"
+	metricProfBuckets      = "/memory/classes/profiling/buckets:bytes"
+	metricOther            = "/memory/classes/other:bytes"
+	metricMemTotal         = "/memory/classes/total:bytes"
+	metricHeapAllocs       = "/gc/heap/allocs:bytes"
+	metricHeapGoal         = "/gc/heap/goal:bytes"
+	metricHeapLive         = "/gc/heap/live:bytes"
+	metricGCCycles         = "/gc/cycles/total:gc-cycles"
+	metricGCPauses         = "/sched/pauses/total/gc:seconds"
+	metricGCPausesOld      = "/gc/pauses:seconds" // go1.22 之前
+	metricSchedLatencies   = "/sched/latencies:seconds"
+	metricGCCpu            = "/cpu/classes/gc/total:cpu-seconds"
+	metricTotalCpu         = "/cpu/classes/total:cpu-seconds"
+	metricMutexWaitSeconds = "/sync/mutex/wait/total:seconds"
+)
+
+var (
+	// 导出直方图时合并成的桶，单位秒
+	runtimeHistogramBuckets = []float64{1e-6, 1e-5, 1e-4, 5e-4, 1e-3, 5e-3, 1e-2, 5e-2, 0.1, 0.5, 1, 5, 10}
+
+	// 内存分类
+	runtimeMemoryClasses = map[string]string{
+		"heap_objects":    metricHeapObjects,
+		"heap_unused":     metricHeapUnused,
+		"heap_free":       metricHeapFree,
+		"heap_released":   metricHeapReleased,
+		"heap_stacks":     metricHeapStacks,
+		"os_stacks":       metricOSStacks,
+		"metadata_other":  metricMetadataOther,
+		"metadata_mcache": metricMCacheInuse,
+		"metadata_mspan":  metricMSpanInuse,
+		"profiling":       metricProfBuckets,
+		"other":           metricOther,
+	}
+)
+
+// runtimeSampler 通过runtime/metrics读取运行时指标，不会像runtime.ReadMemStats一样stop the world
+type runtimeSampler struct {
+	mu      sync.Mutex
+	samples []metrics.Sample
+	index   map[string]int
+	window  int // 窗口内的采样数，按采集间隔换算
+
+	// 窗口内的累计值，用于计算窗口内的增量
+	gcCpu          []float64
+	totalCpu       []float64
+	gcPauses       []*metrics.Float64Histogram
+	schedLatencies []*metrics.Float64Histogram
+
+	descGCPauses       *prometheus.Desc
+	descSchedLatencies *prometheus.Desc
+	descGCCpuFraction  *prometheus.Desc
+	descGCCycles       *prometheus.Desc
+	descAllocBytes     *prometheus.Desc
+	descSysBytes       *prometheus.Desc
+	descHeapAlloc      *prometheus.Desc
+	descHeapInuse      *prometheus.Desc
+	descHeapIdle       *prometheus.Desc
+	descHeapSys        *prometheus.Desc
+	descHeapReleased   *prometheus.Desc
+	descHeapGoal       *prometheus.Desc
+	descHeapLive       *prometheus.Desc
+	descMemoryClasses  *prometheus.Desc
+	descMutexWait      *prometheus.Desc
+
+	metrics Metrics // 最近一次采样的结果
+}
+
+func newRuntimeSampler(namespace string, constLabels prometheus.Labels) *runtimeSampler {
+	newRuntimeDesc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "runtime", name), help, labels, constLabels)
+	}
+
+	names := []string{
+		metricHeapObjects, metricHeapUnused, metricHeapFree, metricHeapReleased,
+		metricHeapStacks, metricOSStacks, metricMetadataOther, metricMCacheInuse,
+		metricMSpanInuse, metricProfBuckets, metricOther, metricMemTotal,
+		metricHeapAllocs, metricHeapGoal, metricHeapLive, metricGCCycles,
+		metricGCPauses, metricGCPausesOld, metricSchedLatencies,
+		metricGCCpu, metricTotalCpu, metricMutexWaitSeconds,
+	}
+
+	s := &runtimeSampler{
+		samples: make([]metrics.Sample, len(names)),
+		index:   make(map[string]int, len(names)),
+
+		descGCPauses:       newRuntimeDesc("gc_pause_seconds", "GC停顿时间分布", nil),
+		descSchedLatencies: newRuntimeDesc("sched_latency_seconds", "协程从可运行到运行的调度延迟分布", nil),
+		descGCCpuFraction:  newRuntimeDesc("gc_cpu_ratio", "最近窗口内GC占用的cpu时间比例 0~1", nil),
+		descGCCycles:       newRuntimeDesc("gc_cycles_total", "已经完成的GC次数", nil),
+		descAllocBytes:     newRuntimeDesc("alloc_bytes_total", "累计拿来进行对象分配的内存大小", nil),
+		descSysBytes:       newRuntimeDesc("sys_bytes", "从操作系统申请到的虚拟内存大小", nil),
+		descHeapAlloc:      newRuntimeDesc("heap_alloc_bytes", "当前分配对象所占的内存大小", nil),
+		descHeapInuse:      newRuntimeDesc("heap_inuse_bytes", "使用中的堆大小", nil),
+		descHeapIdle:       newRuntimeDesc("heap_idle_bytes", "空闲的堆大小", nil),
+		descHeapSys:        newRuntimeDesc("heap_sys_bytes", "从操作系统申请到的堆虚拟内存大小", nil),
+		descHeapReleased:   newRuntimeDesc("heap_released_bytes", "释放返回给操作系统的堆大小", nil),
+		descHeapGoal:       newRuntimeDesc("heap_goal_bytes", "下一次GC的堆目标大小", nil),
+		descHeapLive:       newRuntimeDesc("heap_live_bytes", "上一次GC后存活的堆大小", nil),
+		descMemoryClasses:  newRuntimeDesc("memory_classes_bytes", "运行时按用途划分的内存大小", []string{"class"}),
+		descMutexWait:      newRuntimeDesc("mutex_wait_seconds_total", "协程等待互斥锁的累计时间", nil),
+	}
+
+	for i, name := range names {
+		s.samples[i].Name = name
+		s.index[name] = i
+	}
+	s.SetInterval(defaultRuntimeInterval)
+
+	return s
+}
+
+// SetInterval 按实际的采集间隔换算窗口内的采样数，首尾两个累计值之差覆盖runtimeWindow
+func (s *runtimeSampler) SetInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.window = 2
+	if interval > 0 && int(runtimeWindow/interval)+1 > s.window {
+		s.window = int(runtimeWindow/interval) + 1
+	}
+	s.gcCpu = trimWindow(s.gcCpu, s.window)
+	s.totalCpu = trimWindow(s.totalCpu, s.window)
+	s.gcPauses = trimWindow(s.gcPauses, s.window)
+	s.schedLatencies = trimWindow(s.schedLatencies, s.window)
+}
+
+// Sample 读取一次运行时指标，并更新到metrics
+func (s *runtimeSampler) Sample(m *Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metrics.Read(s.samples)
+
+	heapObjects := s.getUint64(metricHeapObjects)
+	heapUnused := s.getUint64(metricHeapUnused)
+	heapFree := s.getUint64(metricHeapFree)
+	heapReleased := s.getUint64(metricHeapReleased)
+
+	// 与runtime.MemStats对应的字段
+	m.Alloc = heapObjects
+	m.HeapAlloc = heapObjects
+	m.TotalAlloc = s.getUint64(metricHeapAllocs)
+	m.Sys = s.getUint64(metricMemTotal)
+	m.NumGC = uint32(s.getUint64(metricGCCycles))
+	m.HeapInuse = heapObjects + heapUnused
+	m.HeapIdle = heapFree + heapReleased
+	m.HeapSys = m.HeapInuse + m.HeapIdle
+	m.HeapReleased = heapReleased
+
+	m.HeapGoal = s.getUint64(metricHeapGoal)
+	m.HeapLive = s.getUint64(metricHeapLive)
+	m.StackInuse = s.getUint64(metricHeapStacks) + s.getUint64(metricOSStacks)
+	m.OtherSys = s.getUint64(metricOther)
+	m.MutexWaitSeconds = s.getFloat64(metricMutexWaitSeconds)
+
+	// 窗口内的gc cpu占比
+	s.gcCpu = pushWindow(s.gcCpu, s.getFloat64(metricGCCpu), s.window)
+	s.totalCpu = pushWindow(s.totalCpu, s.getFloat64(metricTotalCpu), s.window)
+	m.GCCpuFraction = 0
+	if totalDelta := s.totalCpu[len(s.totalCpu)-1] - s.totalCpu[0]; totalDelta > 0 {
+		m.GCCpuFraction = (s.gcCpu[len(s.gcCpu)-1] - s.gcCpu[0]) / totalDelta
+	}
+
+	// 窗口内的p99
+	s.gcPauses = pushWindow(s.gcPauses, s.gcPausesHistogram(), s.window)
+	s.schedLatencies = pushWindow(s.schedLatencies, s.getHistogram(metricSchedLatencies), s.window)
+	m.GCPauseP99 = windowQuantile(s.gcPauses, 0.99)
+	m.SchedLatencyP99 = windowQuantile(s.schedLatencies, 0.99)
+
+	s.metrics = *m
+}
+
+func (s *runtimeSampler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.descGCPauses
+	ch <- s.descSchedLatencies
+	ch <- s.descGCCpuFraction
+	ch <- s.descGCCycles
+	ch <- s.descAllocBytes
+	ch <- s.descSysBytes
+	ch <- s.descHeapAlloc
+	ch <- s.descHeapInuse
+	ch <- s.descHeapIdle
+	ch <- s.descHeapSys
+	ch <- s.descHeapReleased
+	ch <- s.descHeapGoal
+	ch <- s.descHeapLive
+	ch <- s.descMemoryClasses
+	ch <- s.descMutexWait
+}
+
+// Collect 导出最近一次采样的值
+func (s *runtimeSampler) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.gcPauses) == 0 {
+		return
+	}
+
+	if h := s.gcPauses[len(s.gcPauses)-1]; h != nil {
+		ch <- constHistogram(s.descGCPauses, h)
+	}
+	if h := s.schedLatencies[len(s.schedLatencies)-1]; h != nil {
+		ch <- constHistogram(s.descSchedLatencies, h)
+	}
+
+	m := &s.metrics
+	ch <- prometheus.MustNewConstMetric(s.descGCCpuFraction, prometheus.GaugeValue, m.GCCpuFraction)
+	ch <- prometheus.MustNewConstMetric(s.descGCCycles, prometheus.CounterValue, float64(m.NumGC))
+	ch <- prometheus.MustNewConstMetric(s.descAllocBytes, prometheus.CounterValue, float64(m.TotalAlloc))
+	ch <- prometheus.MustNewConstMetric(s.descSysBytes, prometheus.GaugeValue, float64(m.Sys))
+	ch <- prometheus.MustNewConstMetric(s.descHeapAlloc, prometheus.GaugeValue, float64(m.HeapAlloc))
+	ch <- prometheus.MustNewConstMetric(s.descHeapInuse, prometheus.GaugeValue, float64(m.HeapInuse))
+	ch <- prometheus.MustNewConstMetric(s.descHeapIdle, prometheus.GaugeValue, float64(m.HeapIdle))
+	ch <- prometheus.MustNewConstMetric(s.descHeapSys, prometheus.GaugeValue, float64(m.HeapSys))
+	ch <- prometheus.MustNewConstMetric(s.descHeapReleased, prometheus.GaugeValue, float64(m.HeapReleased))
+	ch <- prometheus.MustNewConstMetric(s.descHeapGoal, prometheus.GaugeValue, float64(m.HeapGoal))
+	ch <- prometheus.MustNewConstMetric(s.descHeapLive, prometheus.GaugeValue, float64(m.HeapLive))
+	for class, name := range runtimeMemoryClasses {
+		ch <- prometheus.MustNewConstMetric(s.descMemoryClasses, prometheus.GaugeValue, float64(s.getUint64(name)), class)
+	}
+	ch <- prometheus.MustNewConstMetric(s.descMutexWait, prometheus.CounterValue, m.MutexWaitSeconds)
+}
+
+func (s *runtimeSampler) getUint64(name string) uint64 {
+	v := s.samples[s.index[name]].Value
+	if v.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return v.Uint64()
+}
+
+func (s *runtimeSampler) getFloat64(name string) float64 {
+	v := s.samples[s.index[name]].Value
+	if v.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return v.Float64()
+}
+
+func (s *runtimeSampler) getHistogram(name string) *metrics.Float64Histogram {
+	v := s.samples[s.index[name]].Value
+	if v.Kind() != metrics.KindFloat64Histogram {
+		return nil
+	}
+
+	// metrics.Read会复用内存，需要拷贝
+	h := v.Float64Histogram()
+	return &metrics.Float64Histogram{
+		Counts:  append([]uint64(nil), h.Counts...),
+		Buckets: h.Buckets,
+	}
+}
+
+func (s *runtimeSampler) gcPausesHistogram() *metrics.Float64Histogram {
+	if h := s.getHistogram(metricGCPauses); h != nil {
+		return h
+	}
+	return s.getHistogram(metricGCPausesOld)
+}
+
+func pushWindow[T any](list []T, v T, window int) []T {
+	return append(trimWindow(list, window-1), v)
+}
+
+// trimWindow 只保留最近的window个采样
+func trimWindow[T any](list []T, window int) []T {
+	if len(list) > window {
+		list = list[len(list)-window:]
+	}
+	return list
+}
+
+// windowQuantile 计算窗口内（最新与最早两个累计直方图之差）的分位数
+func windowQuantile(list []*metrics.Float64Histogram, q float64) float64 {
+	if len(list) == 0 {
+		return 0
+	}
+
+	first, last := list[0], list[len(list)-1]
+	if first == nil || last == nil || len(first.Counts) != len(last.Counts) {
+		return 0
+	}
+
+	var total uint64
+	delta := make([]uint64, len(last.Counts))
+	for i := range last.Counts {
+		delta[i] = last.Counts[i] - first.Counts[i]
+		total += delta[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(float64(total) * q))
+	var count uint64
+	for i, c := range delta {
+		count += c
+		if count >= rank {
+			// 取桶的上界，最后一个桶上界为+Inf时取下界
+			upper := last.Buckets[i+1]
+			if math.IsInf(upper, 1) {
+				return last.Buckets[i]
+			}
+			return upper
+		}
+	}
+
+	return 0
+}
+
+// constHistogram 把运行时直方图合并成runtimeHistogramBuckets的桶，sum用桶的中点估算
+func constHistogram(desc *prometheus.Desc, h *metrics.Float64Histogram) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(runtimeHistogramBuckets))
+	for _, bound := range runtimeHistogramBuckets {
+		buckets[bound] = 0
+	}
+
+	var count uint64
+	var sum float64
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+
+		lower, upper := h.Buckets[i], h.Buckets[i+1]
+		count += c
+		switch {
+		case math.IsInf(lower, -1):
+			sum += upper * float64(c)
+		case math.IsInf(upper, 1):
+			sum += lower * float64(c)
+		default:
+			sum += (lower + upper) / 2 * float64(c)
+		}
+
+		for _, bound := range runtimeHistogramBuckets {
+			if upper <= bound {
+				buckets[bound] += c
+			}
+		}
+	}
+
+	return prometheus.MustNewConstHistogram(desc, count, sum, buckets)
+}
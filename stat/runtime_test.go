@@ -0,0 +1,61 @@
+package stat
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestWindowQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0.001, 0.01, 0.1, math.Inf(1)}
+	list := []*metrics.Float64Histogram{
+		{Counts: []uint64{0, 10, 0, 0}, Buckets: buckets},
+		{Counts: []uint64{0, 100, 9, 1}, Buckets: buckets},
+	}
+
+	if q := windowQuantile(list, 0.5); q != 0.01 {
+		t.Errorf("p50: got %v, want 0.01", q)
+	}
+	if q := windowQuantile(list, 0.99); q != 0.1 {
+		t.Errorf("p99: got %v, want 0.1", q)
+	}
+}
+
+func TestRuntimeSampler_Collect(t *testing.T) {
+	s := newRuntimeSampler(defaultNamespace, nil)
+	var m Metrics
+	s.Sample(&m)
+	s.Sample(&m)
+
+	if m.HeapAlloc == 0 || m.Sys == 0 {
+		t.Fatalf("empty runtime metrics: %+v", m)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRuntimeSampler_SetInterval(t *testing.T) {
+	s := newRuntimeSampler(defaultNamespace, nil)
+	var m Metrics
+	for range 20 {
+		s.Sample(&m)
+	}
+	if len(s.gcCpu) != 11 {
+		t.Fatalf("window at 1s: got %d samples, want 11", len(s.gcCpu))
+	}
+
+	s.SetInterval(5 * time.Second)
+	if len(s.gcCpu) != 3 || len(s.schedLatencies) != 3 {
+		t.Fatalf("window at 5s: got %d/%d samples, want 3", len(s.gcCpu), len(s.schedLatencies))
+	}
+	s.Sample(&m)
+	if len(s.gcCpu) != 3 {
+		t.Fatalf("window after sample: got %d samples, want 3", len(s.gcCpu))
+	}
+}
@@ -0,0 +1,203 @@
+package stat
+
+import "time"
+
+const (
+	defaultProcessInterval = 250 * time.Millisecond
+	defaultRuntimeInterval = time.Second
+	defaultCollectInterval = time.Second // 更新滚动窗口、发布快照和通知OnCollect的间隔，即规则的检查周期
+	minTickInterval        = 10 * time.Millisecond
+)
+
+// Sampler 指标采集器，比如internal中读取/proc和cgroup的采集器，由Stat的调度器按采集间隔调用并把结果写入Metrics
+type Sampler interface {
+	Name() string
+	Sample(m *Metrics) error
+}
+
+// IntervalSetter 需要知道实际采集间隔的采集器实现，比如按时间换算观察窗口和斜率的泄漏检测，
+// 调度器开始采集前在调度协程中调用一次，interval为按tick取整后的间隔
+type IntervalSetter interface {
+	SetInterval(interval time.Duration)
+}
+
+type samplerEntry struct {
+	sampler  Sampler
+	interval time.Duration
+	every    int64 // 每隔多少个tick采集一次
+}
+
+// WithSampler 注册额外的采集器，每隔interval调用一次
+func WithSampler(sampler Sampler, interval time.Duration) Option {
+	return func(stat *Stat) {
+		stat.samplers = append(stat.samplers, samplerEntry{sampler: sampler, interval: interval})
+	}
+}
+
+// WithInterval 修改采集器的采集间隔，name为采集器的Name()，内置的有process(250ms)和runtime(1s)，interval为0时不采集
+func WithInterval(name string, interval time.Duration) Option {
+	return func(stat *Stat) {
+		if stat.intervals == nil {
+			stat.intervals = make(map[string]time.Duration)
+		}
+		stat.intervals[name] = interval
+	}
+}
+
+// OnCollect 每秒调用一次fn，m为最近一次采集后的快照，fn在调度协程中执行，不要阻塞；
+// m.Windows会被下一轮复用，fn返回后还要使用时调用Snapshot
+func (stat *Stat) OnCollect(fn func(m *Metrics)) {
+	stat.mu.Lock()
+	defer stat.mu.Unlock()
+
+	stat.listeners = append(stat.listeners, fn)
+}
+
+// Start 开始采集，多次调用只会启动一次
+func (stat *Stat) Start() {
+	stat.startOnce.Do(func() {
+		go stat.schedule()
+	})
+}
+
+// Stop 停止采集并等待调度协程退出，停止后不能再次Start；不要在OnCollect中调用
+func (stat *Stat) Stop() {
+	stat.stopOnce.Do(func() {
+		close(stat.stop)
+	})
+	// 还没有启动时不再启动
+	stat.startOnce.Do(func() {
+		close(stat.done)
+	})
+
+	<-stat.done
+}
+
+/*
+schedule 所有采集器由同一个ticker驱动，tick为采集间隔的最大公约数，不会因为采集耗时而漂移；
+到期的采集器每个tick写入同一份Metrics，滚动窗口只放入这个tick采集到的值，每隔collectInterval统计一次窗口，再一次性发布快照，最后通知OnCollect，
+所以触发规则看到的总是同一轮采集的结果，也不会因为采集间隔变短而检查得更频繁
+*/
+func (stat *Stat) schedule() {
+	defer close(stat.done)
+
+	entries := make([]samplerEntry, 0, len(stat.samplers))
+	var tick time.Duration
+	for _, entry := range stat.samplers {
+		if interval, ok := stat.intervals[entry.sampler.Name()]; ok {
+			entry.interval = interval
+		}
+		if entry.interval <= 0 {
+			continue
+		}
+
+		tick = gcd(tick, entry.interval)
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if tick < minTickInterval {
+		tick = minTickInterval
+	}
+	for i := range entries {
+		entries[i].every = ticks(entries[i].interval, tick)
+		if setter, ok := entries[i].sampler.(IntervalSetter); ok {
+			setter.SetInterval(time.Duration(entries[i].every) * tick)
+		}
+	}
+	collectEvery := ticks(stat.collectInterval, tick)
+	collectInterval := time.Duration(collectEvery) * tick
+
+	// 滚动窗口在产生这个值的采集器采集后放入，长度按它的采集间隔计算；采集器没有启用时窗口一直为空
+	owners := make([]int, len(stat.windows)) // 窗口对应的entries下标，-1为每隔collectInterval放入
+	for i := range stat.windows {
+		owners[i] = -1
+		interval := collectInterval
+		if name := stat.windows[i].sampler; name != "" {
+			owners[i] = len(entries)
+			for j, entry := range entries {
+				if entry.sampler.Name() == name {
+					owners[i] = j
+					interval = time.Duration(entry.every) * tick
+				}
+			}
+		}
+
+		size := int((stat.windows[i].duration + interval - 1) / interval)
+		stat.windows[i].window = NewWindow(size, interval)
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	m := Metrics{Windows: make(map[string]WindowStats, len(stat.windows))}
+	sampled := make([]bool, len(entries)+1) // 这个tick采集过的采集器，最后一个始终为false
+	collected := false
+	for n := int64(1); ; n++ {
+		select {
+		case <-ticker.C:
+		case <-stat.stop:
+			return
+		}
+
+		for i, entry := range entries {
+			sampled[i] = n%entry.every == 0
+			if !sampled[i] {
+				continue
+			}
+
+			collected = true
+			if err := entry.sampler.Sample(&m); err != nil {
+				stat.logger.Debug("sample failed", "sampler", entry.sampler.Name(), "err", err)
+			}
+		}
+
+		isCollect := collected && n%collectEvery == 0
+		for i, entry := range stat.windows {
+			if owner := owners[i]; (owner >= 0 && sampled[owner]) || (owner < 0 && isCollect) {
+				entry.window.Add(entry.value(&m))
+			}
+		}
+		if !isCollect {
+			continue
+		}
+		collected = false
+
+		// Windows复用同一个map，和快照共享，在锁内更新
+		stat.mu.Lock()
+		for _, entry := range stat.windows {
+			m.Windows[entry.name] = entry.window.Stats()
+		}
+		m.CpuUsageStdDeviation = m.Windows[WindowCpuUsage].Stddev
+
+		// 采集器每次都会创建新的切片和map，直接拷贝即可
+		snapshot := m
+		stat.Metrics = snapshot
+		listeners := stat.listeners
+		stat.mu.Unlock()
+
+		for _, listener := range listeners {
+			listener(&snapshot)
+		}
+	}
+}
+
+// ticks interval相当于多少个tick，至少为1
+func ticks(interval, tick time.Duration) int64 {
+	n := int64((interval + tick/2) / tick)
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// gcd 最大公约数，a为0时返回b
+func gcd(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
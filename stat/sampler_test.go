@@ -0,0 +1,123 @@
+package stat
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+type countSampler struct {
+	name  string
+	count int
+	set   func(m *Metrics, count int)
+}
+
+func (s *countSampler) Name() string {
+	return s.name
+}
+
+func (s *countSampler) Sample(m *Metrics) error {
+	s.count++
+	s.set(m, s.count)
+	return nil
+}
+
+func TestGcd(t *testing.T) {
+	if got := gcd(gcd(0, 250*time.Millisecond), time.Second); got != 250*time.Millisecond {
+		t.Errorf("got %v", got)
+	}
+	if got := gcd(300*time.Millisecond, time.Second); got != 100*time.Millisecond {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestStat_Schedule(t *testing.T) {
+	fast := &countSampler{name: "fast", set: func(m *Metrics, count int) { m.GoroutineNum = count }}
+	slow := &countSampler{name: "slow", set: func(m *Metrics, count int) { m.CpuNum = count }}
+	s := New(
+		WithSampler(fast, 20*time.Millisecond),
+		WithSampler(slow, time.Hour),
+		WithInterval("slow", 40*time.Millisecond),
+		WithInterval("process", 0),
+		WithInterval("runtime", 0),
+	)
+	s.collectInterval = 40 * time.Millisecond
+	s.windows = []windowEntry{
+		{name: "fast", sampler: "fast", duration: 200 * time.Millisecond, value: func(m *Metrics) float64 { return float64(m.GoroutineNum) }},
+		{name: "slow", sampler: "slow", duration: 200 * time.Millisecond, value: func(m *Metrics) float64 { return float64(m.CpuNum) }},
+	}
+	defer s.Stop()
+
+	rounds := make(chan Metrics, 100)
+	s.OnCollect(func(m *Metrics) {
+		// Windows会被下一轮复用
+		snapshot := *m
+		snapshot.Windows = maps.Clone(m.Windows)
+		rounds <- snapshot
+	})
+	s.Start()
+
+	// 每隔collectInterval通知一次，快照里两个采集器的结果是同一个tick的
+	for i := 1; i <= 4; i++ {
+		m := <-rounds
+		if m.GoroutineNum != 2*i || m.CpuNum != i {
+			t.Fatalf("round %d: fast %d, slow %d", i, m.GoroutineNum, m.CpuNum)
+		}
+
+		// 窗口只放入采集器自己采集到的值，长度按采集器的间隔计算
+		fast, slow := m.Window("fast"), m.Window("slow")
+		if fast.Count != 2*i || fast.Last != float64(2*i) || slow.Count != i || slow.Last != float64(i) {
+			t.Fatalf("round %d: fast window %+v, slow window %+v", i, fast, slow)
+		}
+	}
+}
+
+func TestStat_Stop(t *testing.T) {
+	sampler := &countSampler{name: "count", set: func(m *Metrics, count int) {}}
+	s := New(
+		WithSampler(sampler, 10*time.Millisecond),
+		WithInterval("process", 0),
+		WithInterval("runtime", 0),
+	)
+	s.Start()
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+
+	// Stop返回后调度协程已经退出
+	count := sampler.count
+	time.Sleep(50 * time.Millisecond)
+	if sampler.count != count {
+		t.Errorf("sampled after stop: %d -> %d", count, sampler.count)
+	}
+
+	// 没有启动时直接返回，之后也不会再启动
+	s = New(WithInterval("process", 0), WithInterval("runtime", 0))
+	s.Stop()
+	s.Start()
+}
+
+type intervalSampler struct {
+	countSampler
+	interval chan time.Duration
+}
+
+func (s *intervalSampler) SetInterval(interval time.Duration) {
+	s.interval <- interval
+}
+
+func TestStat_SetInterval(t *testing.T) {
+	sampler := &intervalSampler{countSampler: countSampler{name: "interval", set: func(m *Metrics, count int) {}}, interval: make(chan time.Duration, 1)}
+	s := New(
+		WithSampler(sampler, time.Second),
+		WithInterval("interval", 30*time.Millisecond),
+		WithInterval("process", 0),
+		WithInterval("runtime", 0),
+	)
+	s.Start()
+	defer s.Stop()
+
+	// 采集器拿到的是修改后的间隔
+	if interval := <-sampler.interval; interval != 30*time.Millisecond {
+		t.Errorf("got interval %v", interval)
+	}
+}
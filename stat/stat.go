@@ -2,52 +2,28 @@ package stat
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/shirou/gopsutil/process"
-	"log"
-	"math"
-	"os"
+	"log/slog"
+	"maps"
 	"runtime"
+	"sync"
 	"time"
 )
 
-var (
-	_gaugeProcessCpuUsage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "process_cpu_usage",
-		Help: "进程cpu使用率 比例为(1/1000)",
-	})
-
-	_gaugeProcessRecentCpuUsageLevel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "process_recent_cpu_usageX",
-		Help: "最近进程cpu使用率 比例为(1/1000)",
-	}, []string{"cpu"})
-
-	_gaugeProcessMemUsage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "process_mem_usage",
-		Help: "进程内存使用率 比例为(1/1000)",
-	})
-
-	_gaugeProcessRecentMemUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "process_recent_mem_usage",
-		Help: "最近进程内存使用率 比例为(1/1000)",
-	}, []string{"mem"})
-
-	_gaugeRuntimeMemInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "runtime_mem_info",
-		Help: "运行时内存信息",
-	}, []string{"runtime_mem"})
-)
-
 type Metrics struct {
 	// 进程级cpu
-	CpuUsage             int64   // 当前
+	CpuUsage             int64   // 当前，比例为(1/1000)
 	PrevCpuUsage1        int64   // 250ms
 	PrevCpuUsage2        int64   // 500ms
-	CpuUsageStdDeviation float64 // cpu标准差
+	CpuUsageStdDeviation float64 // cpu标准差，最近5秒的cpu_usage窗口
 
 	// 进程级别内存
-	MemUsage      int64
-	PrevMemUsage1 int64
-	PrevMemUsage2 int64
+	MemUsage      int64 // 当前
+	PrevMemUsage1 int64 // 250ms
+	PrevMemUsage2 int64 // 500ms
+
+	// 进程级别 /proc/self
+	Process ProcessStat
+	Tcp     TcpStat
 
 	// 运行时级别
 	CpuNum       int    // 可用逻辑cpu核心数
@@ -61,149 +37,410 @@ type Metrics struct {
 	HeapAlloc    uint64 // 当前分配对象所占的内存大小
 	HeapSys      uint64 // 从操作系统申请到堆虚拟内存大小
 	HeapReleased uint64 // 释放返回给操作系统的堆的大小
+
+	// 运行时级别 runtime/metrics
+	HeapGoal         uint64  // 下一次GC的堆目标大小
+	HeapLive         uint64  // 上一次GC后存活的堆大小
+	StackInuse       uint64  // 协程栈和系统线程栈的大小
+	OtherSys         uint64  // 运行时其他用途的内存大小
+	MutexWaitSeconds float64 // 协程等待互斥锁的累计时间，单位秒
+	GCCpuFraction    float64 // 最近10秒GC占用的cpu时间比例，窗口按runtime的采集间隔换算
+	GCPauseP99       float64 // 最近10秒GC停顿的p99，单位秒
+	SchedLatencyP99  float64 // 最近10秒调度延迟的p99，单位秒
+
+	// 协程泄漏
+	GoroutineLeakSuspected bool             // 是否疑似协程泄漏
+	GoroutineSlope         float64          // 协程数增长斜率，单位 个/秒
+	GoroutineLeakGroups    []GoroutineGroup // 疑似泄漏时增长最快的分组
+
+	// 滚动窗口的统计，key为窗口名
+	Windows map[string]WindowStats
+
+	// 主机级别cpu /proc/stat
+	HostCpu      CpuModes   // 所有核心合计
+	HostCpuCores []CpuModes // 每个核心
+
+	// cgroup级别cpu
+	CgroupCpu CgroupCpu
+
+	// PSI 资源不足导致任务等待的时间比例
+	HostPressure   Pressure // /proc/pressure
+	CgroupPressure Pressure // cgroup v2的*.pressure
+}
+
+// PressureLine PSI的一行，avg为百分比 0~100，total为累计等待时间，单位us
+type PressureLine struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  uint64
+}
+
+// PressureStat 一种资源的PSI，some为至少有一个任务在等待，full为所有任务都在等待
+type PressureStat struct {
+	Some PressureLine
+	Full PressureLine
+}
+
+// Pressure cpu、内存、io的PSI
+type Pressure struct {
+	Available bool // 是否采集到
+	Cpu       PressureStat
+	Memory    PressureStat
+	Io        PressureStat
+}
+
+// Window 返回滚动窗口的统计，没有这个窗口时返回零值
+func (m *Metrics) Window(name string) WindowStats {
+	return m.Windows[name]
+}
+
+// Pressure 优先返回cgroup的PSI，不在cgroup v2中时返回主机的PSI
+func (m *Metrics) Pressure() Pressure {
+	if m.CgroupPressure.Available {
+		return m.CgroupPressure
+	}
+
+	return m.HostPressure
+}
+
+// CgroupCpu cgroup的cpu配额和限流情况
+type CgroupCpu struct {
+	Available        bool    // 是否读取到了cpu.stat，限流的计数和配额分开输出
+	QuotaCores       float64 // 配额换算成的核心数，0为不限制
+	PeriodSeconds    float64 // 时间周期，单位秒，0为没有采集
+	CpusetCpus       int     // 可以使用的cpu核心数
+	Periods          uint64  // 累计经过的时间周期数
+	ThrottledPeriods uint64  // 累计被限流的时间周期数
+	ThrottledSeconds float64 // 累计被限流的时间，单位秒
+	ThrottledRatio   float64 // 最近一次采样间隔内被限流的时间周期比例
+}
+
+// ProcessStat 进程的fd、线程、上下文切换和io，都是当前值或累计值
+type ProcessStat struct {
+	Available              bool    // 是否采集到
+	OpenFds                uint64  // 打开的fd数
+	MaxFds                 uint64  // RLIMIT_NOFILE，0为不限制
+	Threads                uint64  // 系统线程数
+	VoluntaryCtxSwitches   uint64  // 主动让出cpu的次数，一般是在等待io或锁
+	InvoluntaryCtxSwitches uint64  // 被抢占的次数
+	ReadBytes              uint64  // 从存储设备读取的字节数
+	WriteBytes             uint64  // 写到存储设备的字节数
+	ReadChars              uint64  // read等系统调用读取的字节数，包括网络和页缓存
+	WriteChars             uint64  // write等系统调用写入的字节数
+	FdLeakSuspected        bool    // 是否疑似fd泄漏
+	FdSlope                float64 // fd数增长斜率，单位 个/秒
+}
+
+// FdUsage 打开的fd数占RLIMIT_NOFILE的比例，没有限制时为0
+func (process *ProcessStat) FdUsage() float64 {
+	if process.MaxFds == 0 {
+		return 0
+	}
+
+	return float64(process.OpenFds) / float64(process.MaxFds)
+}
+
+// TcpSocketKey tcp连接按状态和本地端口分组，不是监听端口的连接端口为client
+type TcpSocketKey struct {
+	State string
+	Port  string
+}
+
+// MarshalText 输出为 ESTABLISHED/8080，Metrics可以编码成json
+func (key TcpSocketKey) MarshalText() ([]byte, error) {
+	return []byte(key.State + "/" + key.Port), nil
+}
+
+// Sockstat /proc/net/sockstat中tcp的统计，是网络命名空间范围的
+type Sockstat struct {
+	Available bool
+	InUse     uint64 // 使用中的tcp socket
+	Orphan    uint64 // 不属于任何进程的tcp socket
+	TimeWait  uint64 // TIME_WAIT的连接，TcpStat中不统计TIME_WAIT，看这里
+	Alloc     uint64 // 已分配的tcp socket
+	MemPages  uint64 // tcp使用的内存，单位页
+}
+
+// TcpStat 当前进程持有的tcp连接，不包括已经不属于任何进程的TIME_WAIT
+type TcpStat struct {
+	Available              bool
+	States                 map[string]int       // 按状态统计
+	Sockets                map[TcpSocketKey]int // 按状态和本地端口统计
+	CloseWaitLeakSuspected bool                 // CLOSE_WAIT是否持续增长
+	CloseWaitSlope         float64              // CLOSE_WAIT增长斜率，单位 个/秒
+	Sockstat               Sockstat
+}
+
+// CpuModes 一段时间内cpu花在各状态上的时间比例，user包含nice
+type CpuModes struct {
+	Cpu     string // cpu、cpu0、cpu1...
+	User    float64
+	System  float64
+	Idle    float64
+	Iowait  float64
+	Irq     float64
+	Softirq float64
+	Steal   float64 // 被宿主机上其他虚拟机抢占的时间
 }
 
 type Stat struct {
-	currentProcess *process.Process
+	goroutineLeak  *GoroutineLeakDetector
+	runtimeSampler *runtimeSampler
+
+	// 配置
+	registerer    prometheus.Registerer
+	namespace     string
+	constLabels   prometheus.Labels
+	legacyMetrics bool
+	logger        *slog.Logger
+	runtimeLog    time.Duration
+	samplers      []samplerEntry
+	intervals     map[string]time.Duration // 按采集器名字修改的采集间隔
+	windows       []windowEntry
+
+	descs *descs
+
+	collectInterval time.Duration
+	startOnce       sync.Once
+	stopOnce        sync.Once
+	stop            chan struct{}
+	done            chan struct{}
+	mu              sync.RWMutex
+	listeners       []func(m *Metrics)
+
+	// Metrics 最近一次采集后的指标
+	//
+	// Deprecated: 直接读取和调度协程的写入没有同步，使用Snapshot或OnCollect
+	Metrics Metrics
+
+	Registry *prometheus.Registry // 未指定registerer时使用的registry
+}
+
+// Option Stat的配置项
+type Option func(stat *Stat)
 
-	Registry *prometheus.Registry
-	Metrics  Metrics
+// WithLegacyMetrics 是否继续输出旧的指标名(process_cpu_usage、runtime_mem_info等)，用于过渡；
+// 默认开启，避免升级后已有的看板失效，迁移到新指标后可以关闭，之后的版本会改为默认关闭
+func WithLegacyMetrics(enable bool) Option {
+	return func(stat *Stat) {
+		stat.legacyMetrics = enable
+	}
 }
 
-func New() *Stat {
-	s := &Stat{}
+// WithLogger 日志，默认为slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(stat *Stat) {
+		stat.logger = logger
+	}
+}
 
-	// 当前进程
-	s.currentProcess, _ = process.NewProcess(int32(os.Getpid()))
-	s.Registry = prometheus.NewRegistry()
-	s.Registry.MustRegister(
-		_gaugeProcessCpuUsage,
-		_gaugeProcessRecentCpuUsageLevel,
-		_gaugeProcessMemUsage,
-		_gaugeProcessRecentMemUsage,
-		_gaugeRuntimeMemInfo,
-	)
+// WithRuntimeLog 每隔interval输出一次运行时指标日志，默认为0不输出
+func WithRuntimeLog(interval time.Duration) Option {
+	return func(stat *Stat) {
+		stat.runtimeLog = interval
+	}
+}
 
-	return s
+// WithRegisterer 把指标注册到调用者的registerer，不再创建新的registry
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(stat *Stat) {
+		stat.registerer = registerer
+	}
 }
 
-// MonitorProcess 监控进程信息
-func (stat *Stat) MonitorProcess() {
-	if stat.currentProcess == nil {
-		return
+// WithNamespace 指标名前缀，默认为dprof
+func WithNamespace(namespace string) Option {
+	return func(stat *Stat) {
+		stat.namespace = namespace
+	}
+}
+
+// WithConstLabels 所有指标都带上的固定label
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(stat *Stat) {
+		stat.constLabels = labels
+	}
+}
+
+func New(opts ...Option) *Stat {
+	s := &Stat{
+		namespace:       defaultNamespace,
+		legacyMetrics:   true,
+		logger:          slog.Default(),
+		windows:         defaultWindows(),
+		collectInterval: defaultCollectInterval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	processCpuMemInterval := 250 * time.Millisecond
+	runtimeInterval := defaultRuntimeInterval
+	if interval, ok := s.intervals["runtime"]; ok && interval > 0 {
+		runtimeInterval = interval
+	}
+	s.goroutineLeak = NewGoroutineLeakDetector(s.logger, runtimeInterval)
+
+	s.descs = newDescs(s.namespace, s.constLabels)
+	s.runtimeSampler = newRuntimeSampler(s.namespace, s.constLabels)
 
-	// 进程 cpu 和 内存
-	go func() {
-		var c1, c2, c3 float64
-		var m1, m2, m3 float64
+	// 内置的采集器在最前面，进程 cpu 和 内存每250ms采集一次，计算抖动
+	builtin := []samplerEntry{{sampler: &runtimeCollector{stat: s}, interval: defaultRuntimeInterval}}
+	if process, err := newProcessSampler(); err == nil {
+		builtin = append([]samplerEntry{{sampler: &processCollector{process: process}, interval: defaultProcessInterval}}, builtin...)
+	}
+	s.samplers = append(builtin, s.samplers...)
 
-		for {
-			time.Sleep(processCpuMemInterval)
+	if s.registerer == nil {
+		s.Registry = prometheus.NewRegistry()
+		s.registerer = s.Registry
+	}
+	s.registerer.MustRegister(s)
 
-			// 拿进程cpu站系统总体cpu的比例
-			cpuUsage, _ := stat.currentProcess.Percent(0)
-			_gaugeProcessCpuUsage.Set(cpuUsage) // 千分之几
+	return s
+}
 
-			c1, c2, c3 = cpuUsage, c1, c2
-			// 平均值
-			avgC := (c1 + c2 + c3) / 3
-			// 方差
-			varianceC := (math.Pow(c1-avgC, 2) + math.Pow(c2-avgC, 2) + math.Pow(c3-avgC, 2)) / 3
-			stdDeviation := math.Sqrt(varianceC)
-			stat.Metrics.CpuUsageStdDeviation = stdDeviation
+// Registerer 返回指标注册到的registerer
+func (stat *Stat) Registerer() prometheus.Registerer {
+	return stat.registerer
+}
 
-			stat.Metrics.CpuUsage = int64(c1)
-			stat.Metrics.PrevCpuUsage1 = int64(c2)
-			stat.Metrics.PrevCpuUsage2 = int64(c3)
+// Namespace 返回指标名前缀
+func (stat *Stat) Namespace() string {
+	return stat.namespace
+}
 
-			_gaugeProcessRecentCpuUsageLevel.WithLabelValues("0ms").Set(c1)
-			_gaugeProcessRecentCpuUsageLevel.WithLabelValues("250ms").Set(c2)
-			_gaugeProcessRecentCpuUsageLevel.WithLabelValues("500ms").Set(c3)
-			_gaugeProcessRecentCpuUsageLevel.WithLabelValues("std deviation").Set(stdDeviation)
+// ConstLabels 返回所有指标都带上的固定label
+func (stat *Stat) ConstLabels() prometheus.Labels {
+	return stat.constLabels
+}
 
-			// 拿进程的内存
-			memUsage, _ := stat.currentProcess.MemoryPercent()
-			_gaugeProcessMemUsage.Set(float64(memUsage * 10)) // 千分之几
+// Snapshot 返回当前指标的拷贝，可以在任意协程中调用
+func (stat *Stat) Snapshot() Metrics {
+	stat.mu.RLock()
+	defer stat.mu.RUnlock()
 
-			m1, m2, m3 = float64(memUsage*10), m1, m2
+	m := stat.Metrics
+	m.Windows = maps.Clone(m.Windows)
 
-			stat.Metrics.MemUsage = int64(m1)
-			stat.Metrics.MemUsage = int64(m2)
-			stat.Metrics.MemUsage = int64(m3)
+	return m
+}
 
-			_gaugeProcessRecentMemUsage.WithLabelValues("0ms").Set(m1)
-			_gaugeProcessRecentMemUsage.WithLabelValues("250ms").Set(m2)
-			_gaugeProcessRecentMemUsage.WithLabelValues("500ms").Set(m3)
-		}
-	}()
+// MonitorProcess 开始采集
+//
+// Deprecated: 使用Start，所有采集器由同一个调度器执行
+func (stat *Stat) MonitorProcess() {
+	stat.Start()
 }
 
+// MonitorGoRuntime 开始采集
+//
+// Deprecated: 使用Start，所有采集器由同一个调度器执行
 func (stat *Stat) MonitorGoRuntime() {
-	if stat.currentProcess == nil {
-		return
-	}
-
-	runtimeInfoInterval := 1 * time.Second
-	runtimeMemInterval := 5 * time.Second // 操作开销高
-
-	// 开销不高的运行信息
-	go func() {
-		for {
-			time.Sleep(runtimeInfoInterval)
-
-			stat.Metrics.CpuNum = runtime.NumCPU()
-			stat.Metrics.GoroutineNum = runtime.NumGoroutine()
-
-			_gaugeRuntimeMemInfo.WithLabelValues("CpuNum").Set(float64(stat.Metrics.CpuNum))
-			_gaugeRuntimeMemInfo.WithLabelValues("Goroutines").Set(float64(stat.Metrics.GoroutineNum))
-		}
-	}()
-
-	// go运行时内存 开销高
-	go func() {
-		for {
-			time.Sleep(runtimeMemInterval)
-
-			var m runtime.MemStats
-			runtime.ReadMemStats(&m)
-
-			// gc相关
-			stat.Metrics.NumGC = m.NumGC
-
-			// 内存相关
-			stat.Metrics.TotalAlloc = m.TotalAlloc
-			stat.Metrics.Alloc = m.Alloc
-			stat.Metrics.Sys = m.Sys
-			stat.Metrics.HeapInuse = m.HeapInuse
-			stat.Metrics.HeapAlloc = m.HeapAlloc
-			stat.Metrics.HeapSys = m.HeapSys
-			stat.Metrics.HeapIdle = m.HeapIdle
-			stat.Metrics.HeapReleased = m.HeapReleased
-
-			_gaugeRuntimeMemInfo.WithLabelValues("TotalAlloc").Set(float64(m.TotalAlloc) / (1024 * 1024))
-			_gaugeRuntimeMemInfo.WithLabelValues("Alloc").Set(float64(m.Alloc) / (1024 * 1024))
-			_gaugeRuntimeMemInfo.WithLabelValues("Sys").Set(float64(m.Sys) / (1024 * 1024))
-			_gaugeRuntimeMemInfo.WithLabelValues("NumGC").Set(float64(m.NumGC))
-			_gaugeRuntimeMemInfo.WithLabelValues("HeapInuse").Set(float64(m.HeapInuse) / (1024 * 1024))
-			_gaugeRuntimeMemInfo.WithLabelValues("HeapAlloc").Set(float64(m.HeapAlloc) / (1024 * 1024))
-			_gaugeRuntimeMemInfo.WithLabelValues("HeapIdle").Set(float64(m.HeapIdle) / (1024 * 1024))
-			_gaugeRuntimeMemInfo.WithLabelValues("HeapReleased").Set(float64(m.HeapReleased) / (1024 * 1024))
-
-			log.Printf("CpuUsage: %d, MemUsage: %d, Goroutines: %d, Alloc: %vm, TotalAlloc: %vm, Sys: %vm, HeapAlloc: %vm, HeapInuse: %vm, HeapIdle: %vm, HeapReleased: %vm, NumGC: %v\n",
-				stat.Metrics.CpuUsage,
-				stat.Metrics.MemUsage,
-				stat.Metrics.GoroutineNum,
-				m.Alloc/(1024*1024),
-				m.TotalAlloc/(1024*1024),
-				m.Sys/(1024*1024),
-				m.HeapAlloc/(1024*1024),
-				m.HeapInuse/(1024*1024),
-				m.HeapIdle/(1024*1024),
-				m.HeapReleased/(1024*1024),
-				m.NumGC)
-		}
-	}()
+	stat.Start()
+}
+
+// processCollector 进程的cpu和内存，cpu的抖动由cpu_usage窗口计算
+type processCollector struct {
+	process    *processSampler
+	c1, c2, c3 float64
+	m1, m2, m3 float64
+}
+
+func (collector *processCollector) Name() string {
+	return "process"
+}
+
+func (collector *processCollector) Sample(m *Metrics) error {
+	// 拿进程cpu站系统总体cpu的比例，千分之几
+	cpuUsage, err := collector.process.Percent()
+	if err != nil {
+		return err
+	}
+
+	collector.c1, collector.c2, collector.c3 = cpuUsage, collector.c1, collector.c2
+
+	// 拿进程的内存，千分之几
+	memUsage, err := collector.process.MemoryPercent()
+	if err != nil {
+		return err
+	}
+
+	collector.m1, collector.m2, collector.m3 = float64(memUsage*10), collector.m1, collector.m2
+
+	m.CpuUsage = int64(collector.c1)
+	m.PrevCpuUsage1 = int64(collector.c2)
+	m.PrevCpuUsage2 = int64(collector.c3)
+
+	m.MemUsage = int64(collector.m1)
+	m.PrevMemUsage1 = int64(collector.m2)
+	m.PrevMemUsage2 = int64(collector.m3)
+
+	return nil
+}
+
+// runtimeCollector 运行时信息，runtime/metrics 不会stop the world，可以每秒读取
+type runtimeCollector struct {
+	stat        *Stat
+	lastLogTime time.Time
+}
+
+func (collector *runtimeCollector) Name() string {
+	return "runtime"
+}
+
+// SetInterval GC和调度延迟的窗口按采集间隔换算
+func (collector *runtimeCollector) SetInterval(interval time.Duration) {
+	collector.stat.runtimeSampler.SetInterval(interval)
+}
+
+func (collector *runtimeCollector) Sample(m *Metrics) error {
+	stat := collector.stat
+	goroutineNum := runtime.NumGoroutine()
+
+	// 协程泄漏检测
+	suspected := stat.goroutineLeak.Add(goroutineNum)
+
+	m.CpuNum = runtime.NumCPU()
+	m.GoroutineNum = goroutineNum
+	m.GoroutineLeakSuspected = suspected
+	m.GoroutineSlope = stat.goroutineLeak.Slope
+	m.GoroutineLeakGroups = nil
+	if suspected {
+		m.GoroutineLeakGroups = stat.goroutineLeak.TopGroups
+	}
+
+	// 运行时内存和gc
+	stat.runtimeSampler.Sample(m)
+
+	// 周期日志，默认关闭
+	if stat.runtimeLog <= 0 || time.Since(collector.lastLogTime) < stat.runtimeLog {
+		return nil
+	}
+	collector.lastLogTime = time.Now()
+
+	stat.logger.Info("runtime metrics",
+		slog.Group("process",
+			"cpu_usage", m.CpuUsage,
+			"mem_usage", m.MemUsage,
+		),
+		slog.Group("runtime",
+			"goroutines", m.GoroutineNum,
+			"heap_alloc", m.HeapAlloc,
+			"heap_inuse", m.HeapInuse,
+			"heap_idle", m.HeapIdle,
+			"heap_released", m.HeapReleased,
+			"sys", m.Sys,
+			"total_alloc", m.TotalAlloc,
+			"num_gc", m.NumGC,
+			"gc_cpu_fraction", m.GCCpuFraction,
+			"gc_pause_p99", time.Duration(m.GCPauseP99*float64(time.Second)),
+			"sched_latency_p99", time.Duration(m.SchedLatencyP99*float64(time.Second)),
+		),
+	)
+
+	return nil
 }
@@ -0,0 +1,65 @@
+package stat
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"testing"
+)
+
+func TestNew_MultipleInstances(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	New(WithRegisterer(reg), WithConstLabels(prometheus.Labels{"instance": "a"}))
+	New(WithRegisterer(reg), WithConstLabels(prometheus.Labels{"instance": "b"}), WithLegacyMetrics(false))
+	New(WithNamespace("other"))
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 旧指标默认开启
+	legacy := 0
+	for _, mf := range mfs {
+		if mf.GetName() == "dprof_runtime_goroutines" && len(mf.Metric) != 2 {
+			t.Errorf("dprof_runtime_goroutines: got %d series, want 2", len(mf.Metric))
+		}
+		if mf.GetName() == "runtime_mem_info" {
+			legacy = len(mf.Metric)
+		}
+	}
+	if legacy == 0 {
+		t.Error("legacy metrics should be enabled by default")
+	}
+}
+
+func TestStat_Collect_CgroupCpuWithoutQuota(t *testing.T) {
+	s := New(WithLegacyMetrics(false))
+	s.Metrics.CgroupCpu = CgroupCpu{Available: true, Periods: 10, ThrottledPeriods: 2}
+
+	mfs, err := s.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	if !names["dprof_cgroup_cpu_throttled_periods_total"] || names["dprof_cgroup_cpu_period_seconds"] {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestMetrics_JSON(t *testing.T) {
+	m := Metrics{}
+	m.Tcp.Sockets = map[TcpSocketKey]int{{State: "CLOSE_WAIT", Port: "8080"}: 3}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"CLOSE_WAIT/8080":3`) {
+		t.Errorf("got %s", b)
+	}
+}
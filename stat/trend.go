@@ -0,0 +1,78 @@
+package stat
+
+// TrendDetector 判断一个计数在窗口内是否持续增长，用于协程和fd的泄漏检测
+type TrendDetector struct {
+	window         int     // 观察窗口，采样数
+	minSlope       float64 // 窗口内最小增长斜率，单位 个/采样
+	monotonicRatio float64 // 窗口内不下降的采样占比
+	samples        []int
+
+	Slope float64 // 窗口内的增长斜率，窗口未满时为0
+}
+
+func NewTrendDetector(window int, minSlope, monotonicRatio float64) *TrendDetector {
+	return &TrendDetector{
+		window:         window,
+		minSlope:       minSlope,
+		monotonicRatio: monotonicRatio,
+		samples:        make([]int, 0, window),
+	}
+}
+
+// Add 放入一次采样，返回窗口内是否持续增长
+func (detector *TrendDetector) Add(num int) bool {
+	if len(detector.samples) == detector.window {
+		copy(detector.samples, detector.samples[1:])
+		detector.samples = detector.samples[:detector.window-1]
+	}
+	detector.samples = append(detector.samples, num)
+
+	detector.Slope = 0
+	if len(detector.samples) < detector.window {
+		return false
+	}
+
+	detector.Slope = slope(detector.samples)
+
+	return detector.Slope >= detector.minSlope && isMostlyIncreasing(detector.samples, detector.monotonicRatio)
+}
+
+// slope 最小二乘法拟合的斜率
+func slope(samples []int) float64 {
+	n := float64(len(samples))
+	if n < 2 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i, v := range samples {
+		x, y := float64(i), float64(v)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// isMostlyIncreasing 不下降的相邻采样占比是否达到ratio
+func isMostlyIncreasing(samples []int, ratio float64) bool {
+	if len(samples) < 2 {
+		return false
+	}
+
+	var notDecreasing int
+	for i := 1; i < len(samples); i++ {
+		if samples[i] >= samples[i-1] {
+			notDecreasing++
+		}
+	}
+
+	return float64(notDecreasing)/float64(len(samples)-1) >= ratio
+}
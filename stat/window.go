@@ -0,0 +1,282 @@
+package stat
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// 内置的滚动窗口，规则中通过 m.Window(name) 引用
+const (
+	WindowCpuUsage        = "cpu_usage"         // 进程cpu使用率，千分之几
+	WindowMemUsage        = "mem_usage"         // 进程内存使用率，千分之几
+	WindowGoroutines      = "goroutines"        // 协程数
+	WindowHeapInuse       = "heap_inuse"        // 使用中的堆大小
+	WindowGCCpuFraction   = "gc_cpu_fraction"   // gc占用cpu的比例
+	WindowSchedLatencyP99 = "sched_latency_p99" // 调度延迟p99，单位秒
+	WindowOpenFds         = "open_fds"          // 打开的fd数
+	WindowThreads         = "threads"           // 系统线程数
+	WindowHostSteal       = "host_steal"        // 主机cpu被抢占的比例
+	WindowCpuThrottled    = "cpu_throttled"     // cgroup限流的时间周期比例
+)
+
+type windowEntry struct {
+	name     string
+	sampler  string // 产生这个值的采集器，只在它采集的那个tick放入，为空时每秒放入一次
+	duration time.Duration
+	value    func(m *Metrics) float64
+	window   *Window
+}
+
+// defaultWindows cpu使用率抖动看最近5秒，其他看最近1分钟
+func defaultWindows() []windowEntry {
+	return []windowEntry{
+		{name: WindowCpuUsage, sampler: "process", duration: 5 * time.Second, value: func(m *Metrics) float64 { return float64(m.CpuUsage) }},
+		{name: WindowMemUsage, sampler: "process", duration: time.Minute, value: func(m *Metrics) float64 { return float64(m.MemUsage) }},
+		{name: WindowGoroutines, sampler: "runtime", duration: time.Minute, value: func(m *Metrics) float64 { return float64(m.GoroutineNum) }},
+		{name: WindowHeapInuse, sampler: "runtime", duration: time.Minute, value: func(m *Metrics) float64 { return float64(m.HeapInuse) }},
+		{name: WindowGCCpuFraction, sampler: "runtime", duration: time.Minute, value: func(m *Metrics) float64 { return m.GCCpuFraction }},
+		{name: WindowSchedLatencyP99, sampler: "runtime", duration: time.Minute, value: func(m *Metrics) float64 { return m.SchedLatencyP99 }},
+		{name: WindowOpenFds, sampler: "procself", duration: time.Minute, value: func(m *Metrics) float64 { return float64(m.Process.OpenFds) }},
+		{name: WindowThreads, sampler: "procself", duration: time.Minute, value: func(m *Metrics) float64 { return float64(m.Process.Threads) }},
+		{name: WindowHostSteal, sampler: "procstat", duration: time.Minute, value: func(m *Metrics) float64 { return m.HostCpu.Steal }},
+		{name: WindowCpuThrottled, sampler: "cgroup_cpu", duration: time.Minute, value: func(m *Metrics) float64 { return m.CgroupCpu.ThrottledRatio }},
+	}
+}
+
+// WithWindow 增加或修改一个滚动窗口，保留最近duration的采样；内置窗口在对应的采集器采集后放入，增加的窗口每秒放入一次value的结果；
+// value为nil时只修改内置窗口的长度
+func WithWindow(name string, duration time.Duration, value func(m *Metrics) float64) Option {
+	return func(stat *Stat) {
+		for i := range stat.windows {
+			if stat.windows[i].name == name {
+				stat.windows[i].duration = duration
+				if value != nil {
+					stat.windows[i].value = value
+				}
+				return
+			}
+		}
+
+		if value != nil {
+			stat.windows = append(stat.windows, windowEntry{name: name, duration: duration, value: value})
+		}
+	}
+}
+
+// WindowStats 滚动窗口的统计结果
+type WindowStats struct {
+	Count  int     // 窗口内的采样数
+	Last   float64 // 最近一次采样
+	Mean   float64
+	Stddev float64 // 总体标准差
+	EWMA   float64 // 指数加权移动平均，平滑系数为 2/(窗口长度+1)
+	Min    float64
+	Max    float64
+	P50    float64
+	P90    float64
+	P99    float64
+	Slope  float64 // 最小二乘法拟合的斜率，单位 每秒
+}
+
+// Window 定长的环形缓冲区，保存最近的采样并计算统计量
+type Window struct {
+	values   []float64
+	next     int
+	count    int
+	alpha    float64
+	ewma     float64
+	interval time.Duration // 采样间隔，用于把斜率换算成每秒
+	sorted   []float64     // 计算分位数时复用
+}
+
+// NewWindow size为保留的采样数，interval为采样间隔
+func NewWindow(size int, interval time.Duration) *Window {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Window{
+		values:   make([]float64, size),
+		alpha:    2 / float64(size+1),
+		interval: interval,
+		sorted:   make([]float64, 0, size),
+	}
+}
+
+// Add 放入一次采样，窗口满了会覆盖最早的采样
+func (w *Window) Add(v float64) {
+	if w.count == 0 {
+		w.ewma = v
+	} else {
+		w.ewma = w.alpha*v + (1-w.alpha)*w.ewma
+	}
+
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+	if w.count < len(w.values) {
+		w.count++
+	}
+}
+
+// Len 窗口内的采样数
+func (w *Window) Len() int {
+	return w.count
+}
+
+// at 按时间顺序的第i个采样
+func (w *Window) at(i int) float64 {
+	start := w.next - w.count
+	if start < 0 {
+		start += len(w.values)
+	}
+
+	return w.values[(start+i)%len(w.values)]
+}
+
+// Last 最近一次采样
+func (w *Window) Last() float64 {
+	if w.count == 0 {
+		return 0
+	}
+
+	return w.at(w.count - 1)
+}
+
+func (w *Window) Mean() float64 {
+	if w.count == 0 {
+		return 0
+	}
+
+	var sum float64
+	for i := 0; i < w.count; i++ {
+		sum += w.at(i)
+	}
+
+	return sum / float64(w.count)
+}
+
+// Stddev 总体标准差
+func (w *Window) Stddev() float64 {
+	if w.count == 0 {
+		return 0
+	}
+
+	mean := w.Mean()
+	var sum float64
+	for i := 0; i < w.count; i++ {
+		d := w.at(i) - mean
+		sum += d * d
+	}
+
+	return math.Sqrt(sum / float64(w.count))
+}
+
+// EWMA 指数加权移动平均
+func (w *Window) EWMA() float64 {
+	return w.ewma
+}
+
+func (w *Window) Min() float64 {
+	if w.count == 0 {
+		return 0
+	}
+
+	min := w.at(0)
+	for i := 1; i < w.count; i++ {
+		min = math.Min(min, w.at(i))
+	}
+
+	return min
+}
+
+func (w *Window) Max() float64 {
+	if w.count == 0 {
+		return 0
+	}
+
+	max := w.at(0)
+	for i := 1; i < w.count; i++ {
+		max = math.Max(max, w.at(i))
+	}
+
+	return max
+}
+
+// Percentile q为0~1，使用最近秩法
+func (w *Window) Percentile(q float64) float64 {
+	if w.count == 0 {
+		return 0
+	}
+
+	w.sort()
+
+	return w.percentile(q)
+}
+
+func (w *Window) sort() {
+	w.sorted = w.sorted[:0]
+	for i := 0; i < w.count; i++ {
+		w.sorted = append(w.sorted, w.at(i))
+	}
+	sort.Float64s(w.sorted)
+}
+
+func (w *Window) percentile(q float64) float64 {
+	i := int(math.Ceil(q*float64(len(w.sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(w.sorted) {
+		i = len(w.sorted) - 1
+	}
+
+	return w.sorted[i]
+}
+
+// Slope 最小二乘法拟合的斜率，单位 每秒
+func (w *Window) Slope() float64 {
+	n := float64(w.count)
+	if n < 2 || w.interval <= 0 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i := 0; i < w.count; i++ {
+		x, y := float64(i), w.at(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator / w.interval.Seconds()
+}
+
+// Stats 计算所有统计量
+func (w *Window) Stats() WindowStats {
+	stats := WindowStats{
+		Count:  w.count,
+		Last:   w.Last(),
+		Mean:   w.Mean(),
+		Stddev: w.Stddev(),
+		EWMA:   w.EWMA(),
+		Min:    w.Min(),
+		Max:    w.Max(),
+		Slope:  w.Slope(),
+	}
+
+	if w.count > 0 {
+		w.sort()
+		stats.P50 = w.percentile(0.5)
+		stats.P90 = w.percentile(0.9)
+		stats.P99 = w.percentile(0.99)
+	}
+
+	return stats
+}
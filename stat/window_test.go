@@ -0,0 +1,52 @@
+package stat
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(4, 500*time.Millisecond)
+	if stats := w.Stats(); stats != (WindowStats{}) {
+		t.Errorf("empty window: got %+v", stats)
+	}
+
+	// 1被覆盖，窗口内为 2 4 6 8
+	for _, v := range []float64{1, 2, 4, 6, 8} {
+		w.Add(v)
+	}
+
+	stats := w.Stats()
+	want := WindowStats{Count: 4, Last: 8, Mean: 5, Stddev: math.Sqrt(5), Min: 2, Max: 8, P50: 4, P90: 8, P99: 8, Slope: 4}
+	stats.EWMA = 0
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+
+	if p := w.Percentile(0.25); p != 2 {
+		t.Errorf("p25: got %v, want 2", p)
+	}
+	if ewma := w.EWMA(); ewma <= 4 || ewma >= 8 {
+		t.Errorf("ewma: got %v", ewma)
+	}
+}
+
+func TestWithWindow(t *testing.T) {
+	s := New(
+		WithWindow(WindowCpuUsage, 10*time.Second, nil),
+		WithWindow("heap_goal", time.Minute, func(m *Metrics) float64 { return float64(m.HeapGoal) }),
+		WithWindow("unknown", time.Minute, nil),
+	)
+
+	durations := make(map[string]time.Duration)
+	for _, entry := range s.windows {
+		durations[entry.name] = entry.duration
+	}
+	if durations[WindowCpuUsage] != 10*time.Second || durations["heap_goal"] != time.Minute {
+		t.Errorf("got %v", durations)
+	}
+	if _, ok := durations["unknown"]; ok {
+		t.Error("window without value should not be added")
+	}
+}